@@ -0,0 +1,125 @@
+// Command etcd-discovery administrates the external host entries of etcd-discovery.
+//
+// Usage:
+//
+//	etcd-discovery external add -service my-db -hostname db.example.com -port postgresql=5432
+//	etcd-discovery external remove -service my-db -uuid <uuid>
+//
+// The etcd client is configured with the same environment variables as the service package. The password
+// of the external host is read from ETCD_DISCOVERY_PASSWORD, so that it does not show in the process list.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Scalingo/etcd-discovery/v8/service"
+)
+
+// passwordEnv is the environment variable holding the password of the external host.
+const passwordEnv = "ETCD_DISCOVERY_PASSWORD"
+
+const usage = `Usage:
+  etcd-discovery external add -service <name> -hostname <hostname> -port <name>=<port> [options]
+  etcd-discovery external remove -service <name> -uuid <uuid>
+`
+
+// portsFlag parses repeated -port name=port flags.
+type portsFlag service.Ports
+
+func (p portsFlag) String() string {
+	ports := make([]string, 0, len(p))
+	for name, port := range p {
+		ports = append(ports, name+"="+port)
+	}
+	return strings.Join(ports, ",")
+}
+
+func (p portsFlag) Set(value string) error {
+	name, port, ok := strings.Cut(value, "=")
+	if !ok || name == "" || port == "" {
+		return fmt.Errorf("invalid port %q, expected <name>=<port>", value)
+	}
+	p[name] = port
+	return nil
+}
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command with the given arguments, and writes its output to stdout.
+func run(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) < 2 || args[0] != "external" {
+		return errors.New(usage)
+	}
+
+	switch args[1] {
+	case "add":
+		return addExternal(ctx, args[2:], stdout)
+	case "remove":
+		return removeExternal(ctx, args[2:])
+	default:
+		return errors.New(usage)
+	}
+}
+
+func addExternal(ctx context.Context, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("external add", flag.ContinueOnError)
+	serviceName := flags.String("service", "", "name of the service")
+	host := service.Host{Ports: service.Ports{}, PrivatePorts: service.Ports{}}
+	flags.StringVar(&host.Hostname, "hostname", "", "hostname of the external host")
+	flags.Var(portsFlag(host.Ports), "port", "port of the external host, as <name>=<port>. Can be repeated")
+	flags.BoolVar(&host.Public, "public", false, "the service is public")
+	flags.StringVar(&host.PrivateHostname, "private-hostname", "", "private hostname of the external host")
+	flags.Var(portsFlag(host.PrivatePorts), "private-port", "private port of the external host, as <name>=<port>. Can be repeated")
+	flags.StringVar(&host.User, "user", "", "user name used to authenticate to the external host. The password is read from "+passwordEnv)
+	flags.BoolVar(&host.Critical, "critical", false, "the service is critical")
+	flags.StringVar(&host.Shard, "shard", "", "shard of the external host")
+	flags.StringVar(&host.Zone, "zone", "", "availability zone of the external host")
+	flags.StringVar(&host.Region, "region", "", "region of the external host")
+	flags.StringVar(&host.UUID, "uuid", "", "UUID of the entry to replace. A new UUID is generated if empty")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *serviceName == "" || host.Hostname == "" || len(host.Ports) == 0 {
+		return errors.New("-service, -hostname and -port are required")
+	}
+	host.Password = os.Getenv(passwordEnv)
+
+	registered, err := service.RegisterExternal(ctx, *serviceName, host)
+	if err != nil {
+		return fmt.Errorf("register external host: %w", err)
+	}
+	fmt.Fprintln(stdout, registered.UUID)
+	return nil
+}
+
+func removeExternal(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("external remove", flag.ContinueOnError)
+	serviceName := flags.String("service", "", "name of the service")
+	uuid := flags.String("uuid", "", "UUID of the external host")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *serviceName == "" || *uuid == "" {
+		return errors.New("-service and -uuid are required")
+	}
+
+	err = service.DeregisterExternal(ctx, *serviceName, *uuid)
+	if err != nil {
+		return fmt.Errorf("remove external host: %w", err)
+	}
+	return nil
+}
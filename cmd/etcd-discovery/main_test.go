@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Scalingo/etcd-discovery/v8/service"
+)
+
+func TestPortsFlag(t *testing.T) {
+	t.Run("It should parse repeated ports", func(t *testing.T) {
+		ports := service.Ports{}
+		flag := portsFlag(ports)
+		require.NoError(t, flag.Set("http=80"))
+		require.NoError(t, flag.Set("https=443"))
+		assert.Equal(t, service.Ports{"http": "80", "https": "443"}, ports)
+	})
+
+	for _, value := range []string{"80", "=80", "http="} {
+		t.Run("It should reject "+value, func(t *testing.T) {
+			err := portsFlag(service.Ports{}).Set(value)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Run("With an unknown command, it should return the usage", func(t *testing.T) {
+		for _, args := range [][]string{nil, {"external"}, {"external", "list"}, {"other", "add"}} {
+			err := run(t.Context(), args, &bytes.Buffer{})
+			require.EqualError(t, err, usage)
+		}
+	})
+
+	t.Run("With missing flags, it should return an error", func(t *testing.T) {
+		err := run(t.Context(), []string{"external", "add", "-service", "test_cmd_external", "-hostname", "db.example.com"}, &bytes.Buffer{})
+		require.EqualError(t, err, "-service, -hostname and -port are required")
+
+		err = run(t.Context(), []string{"external", "remove", "-service", "test_cmd_external"}, &bytes.Buffer{})
+		require.EqualError(t, err, "-service and -uuid are required")
+	})
+
+	t.Run("With a password flag, it should return an error", func(t *testing.T) {
+		err := run(t.Context(), []string{"external", "add", "-service", "test_cmd_external", "-hostname", "db.example.com", "-port", "postgresql=5432", "-password", "secret"}, &bytes.Buffer{})
+		require.Error(t, err)
+	})
+
+	t.Run("With an invalid port, it should return an error", func(t *testing.T) {
+		err := run(t.Context(), []string{"external", "add", "-service", "test_cmd_external", "-hostname", "db.example.com", "-port", "5432"}, &bytes.Buffer{})
+		require.Error(t, err)
+	})
+
+	t.Run("It should add and remove an external host", func(t *testing.T) {
+		t.Setenv(passwordEnv, "secret")
+		stdout := &bytes.Buffer{}
+		err := run(t.Context(), []string{
+			"external", "add",
+			"-service", "test_cmd_external",
+			"-hostname", "db.example.com",
+			"-port", "postgresql=5432",
+			"-critical",
+			"-zone", "eu-1a",
+			"-user", "admin",
+		}, stdout)
+		require.NoError(t, err)
+		uuid := strings.TrimSpace(stdout.String())
+		assert.True(t, strings.HasSuffix(uuid, "-db.example.com"))
+
+		hosts, err := service.Get(t.Context(), "test_cmd_external").All(t.Context())
+		require.NoError(t, err)
+		require.Len(t, hosts, 1)
+		assert.Equal(t, uuid, hosts[0].UUID)
+		assert.True(t, hosts[0].External)
+		assert.True(t, hosts[0].Critical)
+		assert.Equal(t, "eu-1a", hosts[0].Zone)
+		assert.Equal(t, "admin", hosts[0].User)
+		assert.Equal(t, "secret", hosts[0].Password)
+		assert.Equal(t, "db.example.com", hosts[0].PrivateHostname)
+		assert.Equal(t, service.Ports{"postgresql": "5432"}, hosts[0].PrivatePorts)
+
+		err = run(t.Context(), []string{"external", "remove", "-service", "test_cmd_external", "-uuid", uuid}, &bytes.Buffer{})
+		require.NoError(t, err)
+
+		_, err = service.Get(t.Context(), "test_cmd_external").All(t.Context())
+		require.ErrorIs(t, err, service.ErrNoHostFound)
+
+		err = run(t.Context(), []string{"external", "remove", "-service", "test_cmd_external", "-uuid", uuid}, &bytes.Buffer{})
+		require.ErrorIs(t, err, service.ErrNoHostFound)
+	})
+}
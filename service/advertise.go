@@ -0,0 +1,91 @@
+package service
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+)
+
+// ErrNoAdvertiseAddress is returned by Registration.Err when no local address matches the advertise options.
+var ErrNoAdvertiseAddress = stderrors.New("no local address matches the advertise options")
+
+// interfaceAddrs lists the addresses of the local network interfaces which are up, by interface name.
+// It is replaced in the tests.
+var interfaceAddrs = func() (map[string][]net.Addr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := map[string][]net.Addr{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		addrs[iface.Name] = ifaceAddrs
+	}
+	return addrs, nil
+}
+
+// advertiseAddress returns the local address in the network cidr, on the interface ifaceName if it is not
+// empty. Without cidr, it returns the first global unicast address of the interface ifaceName.
+func advertiseAddress(cidr, ifaceName string) (string, error) {
+	var network *net.IPNet
+	if cidr != "" {
+		var err error
+		_, network, err = net.ParseCIDR(cidr)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return "", fmt.Errorf("list the network interfaces: %w", err)
+	}
+
+	// Sort the interfaces to always advertise the same address
+	names := make([]string, 0, len(addrs))
+	for name := range addrs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if ifaceName != "" && name != ifaceName {
+			continue
+		}
+		for _, addr := range addrs[name] {
+			var ip net.IP
+			switch addr := addr.(type) {
+			case *net.IPNet:
+				ip = addr.IP
+			case *net.IPAddr:
+				ip = addr.IP
+			default:
+				continue
+			}
+
+			if network != nil && network.Contains(ip) || network == nil && ip.IsGlobalUnicast() {
+				return ip.String(), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("%w (network: %q, interface: %q)", ErrNoAdvertiseAddress, cidr, ifaceName)
+}
+
+// advertiseOptionsFromEnv returns the advertise options from the environment, if they are not set.
+func advertiseOptionsFromEnv(opts RegisterOptions) RegisterOptions {
+	if opts.AdvertiseCIDR == "" && opts.AdvertiseInterface == "" {
+		opts.AdvertiseCIDR = os.Getenv("ETCD_DISCOVERY_ADVERTISE_CIDR")
+		opts.AdvertiseInterface = os.Getenv("ETCD_DISCOVERY_ADVERTISE_INTERFACE")
+	}
+	return opts
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func useInterfaceAddrs(t *testing.T, addrs map[string][]net.Addr) {
+	t.Helper()
+
+	previous := interfaceAddrs
+	interfaceAddrs = func() (map[string][]net.Addr, error) {
+		return addrs, nil
+	}
+	t.Cleanup(func() {
+		interfaceAddrs = previous
+	})
+}
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+
+	ip, network, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	network.IP = ip
+	return network
+}
+
+func TestAdvertiseAddress(t *testing.T) {
+	useInterfaceAddrs(t, map[string][]net.Addr{
+		"lo":   {mustParseCIDR(t, "127.0.0.1/8"), mustParseCIDR(t, "::1/128")},
+		"eth0": {mustParseCIDR(t, "172.17.0.2/16"), mustParseCIDR(t, "fd00::2/64")},
+		"eth1": {mustParseCIDR(t, "10.0.0.5/24")},
+	})
+
+	tests := map[string]struct {
+		cidr          string
+		iface         string
+		expectedAddr  string
+		expectedError error
+	}{
+		"With an IPv4 network": {
+			cidr:         "10.0.0.0/8",
+			expectedAddr: "10.0.0.5",
+		},
+		"With an IPv6 network": {
+			cidr:         "fd00::/8",
+			expectedAddr: "fd00::2",
+		},
+		"With an interface only, it should return its first global unicast address": {
+			iface:        "eth0",
+			expectedAddr: "172.17.0.2",
+		},
+		"With a network on another interface": {
+			cidr:          "10.0.0.0/8",
+			iface:         "eth0",
+			expectedError: ErrNoAdvertiseAddress,
+		},
+		"With an unknown network": {
+			cidr:          "192.168.0.0/16",
+			expectedError: ErrNoAdvertiseAddress,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			addr, err := advertiseAddress(test.cidr, test.iface)
+			if test.expectedError != nil {
+				require.ErrorIs(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedAddr, addr)
+		})
+	}
+}
+
+func TestRegisterAdvertiseCIDR(t *testing.T) {
+	useInterfaceAddrs(t, map[string][]net.Addr{
+		"eth0": {mustParseCIDR(t, "10.0.0.5/24")},
+	})
+
+	t.Run("Without private hostname, it should advertise the address in the network", func(t *testing.T) {
+		host := genHost("test-advertise")
+		host.PrivateHostname = ""
+		w := registerForTest(t, t.Context(), "test_advertise", host, RegisterOptions{AdvertiseCIDR: "10.0.0.0/8"})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		h, err := Get(t.Context(), "test_advertise").First(t.Context()).Host(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.5", h.PrivateHostname)
+	})
+
+	t.Run("With the environment variable, it should advertise the address in the network", func(t *testing.T) {
+		t.Setenv("ETCD_DISCOVERY_ADVERTISE_CIDR", "10.0.0.0/8")
+		host := genHost("test-advertise-env")
+		host.PrivateHostname = ""
+		w := registerForTest(t, t.Context(), "test_advertise_env", host, RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+		assert.True(t, strings.HasSuffix(w.UUID(), "-10.0.0.5"))
+	})
+
+	t.Run("With a private hostname, it should keep it", func(t *testing.T) {
+		host := genHost("test-advertise-private")
+		w := registerForTest(t, t.Context(), "test_advertise_private", host, RegisterOptions{AdvertiseCIDR: "192.168.0.0/16"})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+	})
+
+	t.Run("Without any address in the network, the registration should stop", func(t *testing.T) {
+		host := genHost("test-advertise-none")
+		host.PrivateHostname = ""
+		w := registerForTest(t, t.Context(), "test_advertise_none", host, RegisterOptions{AdvertiseCIDR: "192.168.0.0/16"})
+		require.ErrorIs(t, w.WaitRegistration(t.Context()), ErrNoAdvertiseAddress)
+	})
+
+	t.Run("With an invalid network, the registration should stop", func(t *testing.T) {
+		w := registerForTest(t, t.Context(), "test_advertise_invalid", genHost("test-advertise-invalid"), RegisterOptions{AdvertiseCIDR: "10.0.0.0"})
+		require.ErrorIs(t, w.WaitRegistration(t.Context()), ErrInvalidRegisterOptions)
+	})
+}
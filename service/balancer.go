@@ -0,0 +1,149 @@
+package service
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Balancer chooses the host of a service receiving the next request. It is used by Service.One and
+// Service.URL when set in the QueryOptions.
+type Balancer interface {
+	// Pick returns one of hosts, which is never empty. shard is the shard requested in the QueryOptions.
+	Pick(service, shard string, hosts Hosts) *Host
+}
+
+// RandomBalancer chooses a host uniformly at random. This is the balancer used when the QueryOptions do not
+// set any.
+type RandomBalancer struct{}
+
+// Pick returns a random host.
+func (RandomBalancer) Pick(_, _ string, hosts Hosts) *Host {
+	return hosts[rand.Intn(len(hosts))]
+}
+
+// RoundRobinBalancer chooses the hosts in turn, ordered by UUID. The turn is kept per service and shard.
+type RoundRobinBalancer struct {
+	mutex sync.Mutex
+	next  map[roundRobinKey]uint64
+}
+
+type roundRobinKey struct {
+	service string
+	shard   string
+}
+
+// NewRoundRobinBalancer returns a RoundRobinBalancer.
+func NewRoundRobinBalancer() *RoundRobinBalancer {
+	return &RoundRobinBalancer{next: map[roundRobinKey]uint64{}}
+}
+
+// Pick returns the host following the previous one picked for this service and shard.
+func (b *RoundRobinBalancer) Pick(service, shard string, hosts Hosts) *Host {
+	hosts = sortedByUUID(hosts)
+	key := roundRobinKey{service: service, shard: shard}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	next := b.next[key]
+	b.next[key] = next + 1
+	return hosts[next%uint64(len(hosts))]
+}
+
+// PowerOfTwoChoicesBalancer draws two hosts at random and chooses the one with the fewest requests in
+// flight. The caller reports its requests with Start.
+type PowerOfTwoChoicesBalancer struct {
+	mutex    sync.Mutex
+	inFlight map[string]int
+}
+
+// NewPowerOfTwoChoicesBalancer returns a PowerOfTwoChoicesBalancer.
+func NewPowerOfTwoChoicesBalancer() *PowerOfTwoChoicesBalancer {
+	return &PowerOfTwoChoicesBalancer{inFlight: map[string]int{}}
+}
+
+// Start reports a request sent to host. The returned function must be called once the request is over.
+//
+//	host, err := s.One(ctx, service.QueryOptions{Balancer: balancer})
+//	if err != nil {
+//		return err
+//	}
+//	done := balancer.Start(host)
+//	defer done()
+func (b *PowerOfTwoChoicesBalancer) Start(host *Host) func() {
+	b.mutex.Lock()
+	b.inFlight[host.UUID]++
+	b.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			b.inFlight[host.UUID]--
+			if b.inFlight[host.UUID] <= 0 {
+				delete(b.inFlight, host.UUID)
+			}
+		})
+	}
+}
+
+// Pick returns the least loaded of two random hosts.
+func (b *PowerOfTwoChoicesBalancer) Pick(_, _ string, hosts Hosts) *Host {
+	if len(hosts) == 1 {
+		return hosts[0]
+	}
+	i := rand.Intn(len(hosts))
+	j := rand.Intn(len(hosts) - 1)
+	if j >= i {
+		j++
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.inFlight[hosts[j].UUID] < b.inFlight[hosts[i].UUID] {
+		return hosts[j]
+	}
+	return hosts[i]
+}
+
+// LeastRecentlyUsedBalancer chooses the host which has not been picked for the longest time. The hosts which
+// have never been picked come first, ordered by UUID.
+type LeastRecentlyUsedBalancer struct {
+	mutex sync.Mutex
+	// lastPicks are the sequence numbers of the last pick of the hosts, by UUID
+	lastPicks map[string]uint64
+	sequence  uint64
+}
+
+// NewLeastRecentlyUsedBalancer returns a LeastRecentlyUsedBalancer.
+func NewLeastRecentlyUsedBalancer() *LeastRecentlyUsedBalancer {
+	return &LeastRecentlyUsedBalancer{lastPicks: map[string]uint64{}}
+}
+
+// Pick returns the least recently picked host.
+func (b *LeastRecentlyUsedBalancer) Pick(_, _ string, hosts Hosts) *Host {
+	hosts = sortedByUUID(hosts)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	picked := hosts[0]
+	for _, host := range hosts[1:] {
+		if b.lastPicks[host.UUID] < b.lastPicks[picked.UUID] {
+			picked = host
+		}
+	}
+	b.sequence++
+	b.lastPicks[picked.UUID] = b.sequence
+	return picked
+}
+
+// sortedByUUID returns a copy of hosts sorted by UUID, since etcd does not guarantee the order of the hosts.
+func sortedByUUID(hosts Hosts) Hosts {
+	sorted := make(Hosts, len(hosts))
+	copy(sorted, hosts)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].UUID < sorted[j].UUID
+	})
+	return sorted
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func genBalancerHosts(uuids ...string) Hosts {
+	hosts := make(Hosts, 0, len(uuids))
+	for _, uuid := range uuids {
+		hosts = append(hosts, &Host{UUID: uuid})
+	}
+	return hosts
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	t.Run("It should pick the hosts in turn, whatever their order", func(t *testing.T) {
+		b := NewRoundRobinBalancer()
+
+		picks := []string{
+			b.Pick("service", "", genBalancerHosts("b", "a", "c")).UUID,
+			b.Pick("service", "", genBalancerHosts("c", "b", "a")).UUID,
+			b.Pick("service", "", genBalancerHosts("a", "b", "c")).UUID,
+			b.Pick("service", "", genBalancerHosts("a", "c", "b")).UUID,
+		}
+		assert.Equal(t, []string{"a", "b", "c", "a"}, picks)
+	})
+
+	t.Run("It should keep a turn per service and shard", func(t *testing.T) {
+		b := NewRoundRobinBalancer()
+		hosts := genBalancerHosts("a", "b")
+
+		assert.Equal(t, "a", b.Pick("service", "shard-1", hosts).UUID)
+		assert.Equal(t, "a", b.Pick("service", "shard-2", hosts).UUID)
+		assert.Equal(t, "a", b.Pick("other", "shard-1", hosts).UUID)
+		assert.Equal(t, "b", b.Pick("service", "shard-1", hosts).UUID)
+	})
+}
+
+func TestPowerOfTwoChoicesBalancer(t *testing.T) {
+	t.Run("It should pick the host with the fewest requests in flight", func(t *testing.T) {
+		b := NewPowerOfTwoChoicesBalancer()
+		hosts := genBalancerHosts("a", "b")
+
+		done := b.Start(hosts[0])
+		for range 10 {
+			assert.Equal(t, "b", b.Pick("service", "", hosts).UUID)
+		}
+
+		done()
+		// Calling done twice must not count the request twice
+		done()
+		b.Start(hosts[1])
+		for range 10 {
+			assert.Equal(t, "a", b.Pick("service", "", hosts).UUID)
+		}
+	})
+
+	t.Run("With a single host, it should pick it", func(t *testing.T) {
+		b := NewPowerOfTwoChoicesBalancer()
+		assert.Equal(t, "a", b.Pick("service", "", genBalancerHosts("a")).UUID)
+	})
+}
+
+func TestLeastRecentlyUsedBalancer(t *testing.T) {
+	b := NewLeastRecentlyUsedBalancer()
+
+	assert.Equal(t, "a", b.Pick("service", "", genBalancerHosts("b", "a")).UUID)
+	assert.Equal(t, "b", b.Pick("service", "", genBalancerHosts("b", "a")).UUID)
+	// A new host has never been picked
+	assert.Equal(t, "c", b.Pick("service", "", genBalancerHosts("b", "a", "c")).UUID)
+	assert.Equal(t, "a", b.Pick("service", "", genBalancerHosts("b", "a", "c")).UUID)
+}
+
+func TestGetWithOptionsBalancer(t *testing.T) {
+	w1 := registerForTest(t, t.Context(), "test_get_with_balancer", genHost("test-balancer-1"), RegisterOptions{})
+	w2 := registerForTest(t, t.Context(), "test_get_with_balancer", genHost("test-balancer-2"), RegisterOptions{})
+	require.NoError(t, w1.WaitRegistration(t.Context()))
+	require.NoError(t, w2.WaitRegistration(t.Context()))
+
+	b := NewRoundRobinBalancer()
+	picks := map[string]int{}
+	for range 4 {
+		host, err := GetWithOptions(t.Context(), "test_get_with_balancer", QueryOptions{Balancer: b}).One(t.Context()).Host(t.Context())
+		require.NoError(t, err)
+		picks[host.UUID]++
+	}
+	assert.Equal(t, map[string]int{w1.UUID(): 2, w2.UUID(): 2}, picks)
+}
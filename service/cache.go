@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"maps"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	etcdv2 "go.etcd.io/etcd/client/v2"
+
+	"github.com/Scalingo/go-utils/errors/v3"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// defaultCacheMaxAge is how long a cache serves its data once it lost its connection to etcd, by default.
+const defaultCacheMaxAge = 1 * time.Minute
+
+// cachePrimeMaxReads is how many reads of the service infos and the hosts are done at most to prime a cache
+// from a snapshot at a single etcd index.
+const cachePrimeMaxReads = 10
+
+// CacheOptions configures the cache of a service.
+type CacheOptions struct {
+	// MaxAge is how long the cached data is served once the cache lost its connection to etcd. Once
+	// exceeded, the queries read etcd directly until the cache is in sync again. Defaults to 1 minute.
+	MaxAge time.Duration
+}
+
+var ErrCacheAlreadyEnabled = stderrors.New("the cache of this service is already enabled")
+
+var (
+	cachesMutex sync.Mutex
+	// caches are indexed by service name. The cache of a service is nil while it is being primed.
+	caches = map[string]*serviceCache{}
+)
+
+// serviceCache keeps the service infos and the hosts of a service in memory.
+type serviceCache struct {
+	maxAge time.Duration
+	infos  *cachedNodes
+	hosts  *cachedNodes
+}
+
+// EnableCache keeps the service infos and the hosts of a service in memory, so that Get, GetForShard and the
+// Service queries do not read etcd anymore. The cache is primed with a snapshot of the service read at a
+// single etcd index, and kept current with a watch from this index.
+// It is disabled once ctx is canceled. ErrCacheAlreadyEnabled is returned if the service is already cached.
+func EnableCache(ctx context.Context, service string, opts CacheOptions) error {
+	if opts.MaxAge == 0 {
+		opts.MaxAge = defaultCacheMaxAge
+	}
+
+	cachesMutex.Lock()
+	_, exists := caches[service]
+	if !exists {
+		caches[service] = nil
+	}
+	cachesMutex.Unlock()
+	if exists {
+		return ErrCacheAlreadyEnabled
+	}
+
+	cache := &serviceCache{
+		maxAge: opts.MaxAge,
+		infos: &cachedNodes{
+			key:   "/services_infos/" + service,
+			parse: func(ctx context.Context, node *etcdv2.Node) (any, error) { return buildServiceFromNode(ctx, node) },
+		},
+		hosts: &cachedNodes{
+			key:       "/services/" + service,
+			recursive: true,
+			parse: func(ctx context.Context, node *etcdv2.Node) (any, error) {
+				host, err := buildHostFromNode(ctx, node)
+				if err != nil {
+					reportSkippedNodes(ctx, service, []SkippedNode{{Key: node.Key, Index: node.ModifiedIndex, Reason: err.Error()}}, false)
+				}
+				return host, err
+			},
+		},
+	}
+
+	indexes, err := cache.prime(ctx)
+	if err != nil {
+		cachesMutex.Lock()
+		delete(caches, service)
+		cachesMutex.Unlock()
+		return errors.Wrap(ctx, err, "prime the cache")
+	}
+	go cache.infos.watch(ctx, indexes[0])
+	go cache.hosts.watch(ctx, indexes[1])
+
+	cachesMutex.Lock()
+	caches[service] = cache
+	cachesMutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		cachesMutex.Lock()
+		if caches[service] == cache {
+			delete(caches, service)
+		}
+		cachesMutex.Unlock()
+	}()
+	return nil
+}
+
+// prime reads the service infos and the hosts, and returns the etcd index to watch each of them from. etcd
+// cannot read two keys at once: they are read in turn until two consecutive reads are done at the same etcd
+// index, meaning that no write happened in between and that both are a snapshot of the service at this index.
+// If etcd is written too often for that, the last reads are kept, each watched from its own index.
+func (c *serviceCache) prime(ctx context.Context) ([2]uint64, error) {
+	nodes := [2]*cachedNodes{c.infos, c.hosts}
+	var indexes [2]uint64
+	for i := range cachePrimeMaxReads {
+		index, err := nodes[i%2].resync(ctx)
+		if err != nil {
+			return indexes, err
+		}
+		indexes[i%2] = index
+		if i > 0 && indexes[0] == indexes[1] {
+			return indexes, nil
+		}
+	}
+
+	logger.Get(ctx).Warnf("No snapshot of '%s' and '%s' at a single etcd index after %d reads", c.infos.key, c.hosts.key, cachePrimeMaxReads)
+	return indexes, nil
+}
+
+// cacheFor returns the cache of a service, or nil if the service is not cached.
+func cacheFor(service string) *serviceCache {
+	cachesMutex.Lock()
+	defer cachesMutex.Unlock()
+	return caches[service]
+}
+
+// serviceInfos returns the cached service infos, and the etcd index they are up to date with. ok is false if
+// the cache is too stale to be used.
+func (c *serviceCache) serviceInfos() (service *Service, index uint64, found bool, ok bool, err error) {
+	values, index, found, ok := c.infos.get(c.maxAge)
+	if !ok || !found || len(values) == 0 {
+		return nil, index, false, ok, nil
+	}
+	if values[0].err != nil {
+		return nil, index, true, true, values[0].err
+	}
+
+	// The callers must not modify the cached service infos
+	s := *values[0].value.(*Service)
+	s.Ports = maps.Clone(s.Ports)
+	return &s, index, true, true, nil
+}
+
+// serviceHosts returns the cached hosts, the etcd index they are up to date with, and the nodes skipped
+// because they are not valid hosts. ok is false if the cache is too stale to be used.
+func (c *serviceCache) serviceHosts() (hosts Hosts, index uint64, skipped []SkippedNode, found bool, ok bool) {
+	values, index, found, ok := c.hosts.get(c.maxAge)
+	if !ok || !found {
+		return nil, index, nil, found, ok
+	}
+
+	hosts = make(Hosts, 0, len(values))
+	for _, value := range values {
+		if value.err != nil {
+			skipped = append(skipped, SkippedNode{Key: value.key, Index: value.index, Reason: value.err.Error()})
+			continue
+		}
+		// The callers must not modify the cached hosts
+		h := *value.value.(*Host)
+		h.Ports = maps.Clone(h.Ports)
+		h.PrivatePorts = maps.Clone(h.PrivatePorts)
+		hosts = append(hosts, &h)
+	}
+	return hosts, index, skipped, true, true
+}
+
+// cachedNodes keeps an etcd key, or the children of an etcd directory if recursive is true, in memory.
+type cachedNodes struct {
+	key       string
+	recursive bool
+	parse     func(ctx context.Context, node *etcdv2.Node) (any, error)
+
+	mutex  sync.Mutex
+	found  bool
+	values map[string]cachedValue
+	// index is the etcd index the values are up to date with
+	index uint64
+	// lostAt is when the cache lost its connection to etcd. It is zero while the cache is in sync.
+	lostAt time.Time
+}
+
+type cachedValue struct {
+	key string
+	// index is the etcd index the node has last been modified at
+	index uint64
+	value any
+	err   error
+}
+
+// get returns the cached values sorted by key, the etcd index they are up to date with, and whether the
+// key exists. ok is false if the cache lost its connection to etcd for longer than maxAge.
+func (c *cachedNodes) get(maxAge time.Duration) ([]cachedValue, uint64, bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.lostAt.IsZero() && time.Since(c.lostAt) > maxAge {
+		return nil, 0, false, false
+	}
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]cachedValue, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, c.values[key])
+	}
+	return values, c.index, c.found, true
+}
+
+// resync reads the key, and returns the etcd index to watch the following modifications from.
+func (c *cachedNodes) resync(ctx context.Context) (uint64, error) {
+	res, err := KAPI().Get(ctx, c.key, &etcdv2.GetOptions{Recursive: c.recursive})
+	var etcdErr etcdv2.Error
+	if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+		c.mutex.Lock()
+		c.found = false
+		c.values = map[string]cachedValue{}
+		c.index = etcdErr.Index
+		c.lostAt = time.Time{}
+		c.mutex.Unlock()
+		return etcdErr.Index, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(ctx, err, "get "+c.key)
+	}
+
+	nodes := etcdv2.Nodes{res.Node}
+	if c.recursive {
+		nodes = res.Node.Nodes
+	}
+	values := make(map[string]cachedValue, len(nodes))
+	for _, node := range nodes {
+		values[node.Key] = c.parseNode(ctx, node)
+	}
+
+	c.mutex.Lock()
+	c.found = true
+	c.values = values
+	c.index = res.Index
+	c.lostAt = time.Time{}
+	c.mutex.Unlock()
+	return res.Index, nil
+}
+
+func (c *cachedNodes) parseNode(ctx context.Context, node *etcdv2.Node) cachedValue {
+	value, err := c.parse(ctx, node)
+	return cachedValue{key: node.Key, index: node.ModifiedIndex, value: value, err: err}
+}
+
+func (c *cachedNodes) watch(ctx context.Context, index uint64) {
+	log := logger.Get(ctx)
+
+	resync := false
+	for {
+		if resync {
+			var err error
+			index, err = c.resync(ctx)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				log.WithError(err).Errorf("Fail to resync the cache of '%s' (%v)", c.key, Client().Endpoints())
+				if sleepOrDone(ctx, 1*time.Second) != nil {
+					return
+				}
+				continue
+			}
+			resync = false
+		}
+
+		watcher := KAPI().Watcher(c.key, &etcdv2.WatcherOptions{AfterIndex: index, Recursive: c.recursive})
+		res, err := watcher.Next(ctx)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if err != nil {
+			// The cache keeps serving its data up to its max age. It is fully resynced afterwards, since
+			// modifications may have been missed, or the watched index may have been cleared.
+			log.WithError(err).Errorf("Lost watcher of '%s' (%v)", c.key, Client().Endpoints())
+			c.mutex.Lock()
+			if c.lostAt.IsZero() {
+				c.lostAt = time.Now()
+			}
+			c.mutex.Unlock()
+			resync = true
+			if sleepOrDone(ctx, 1*time.Second) != nil {
+				return
+			}
+			continue
+		}
+		index = res.Node.ModifiedIndex
+		c.apply(ctx, res)
+	}
+}
+
+// apply updates the cache with a watch event.
+func (c *cachedNodes) apply(ctx context.Context, res *etcdv2.Response) {
+	removed := res.Action == "delete" || res.Action == "expire" || res.Action == "compareAndDelete"
+
+	var value cachedValue
+	if !removed && !res.Node.Dir {
+		value = c.parseNode(ctx, res.Node)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.index = res.Node.ModifiedIndex
+	switch {
+	case res.Node.Key == c.key && removed:
+		c.found = false
+		c.values = map[string]cachedValue{}
+	case res.Node.Key == c.key:
+		c.found = true
+		if !res.Node.Dir {
+			c.values = map[string]cachedValue{res.Node.Key: value}
+		}
+	case path.Dir(res.Node.Key) != c.key:
+		// Only the direct children of the directory are cached
+	case removed:
+		delete(c.values, res.Node.Key)
+	default:
+		c.found = true
+		c.values[res.Node.Key] = value
+	}
+}
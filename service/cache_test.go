@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnableCache(t *testing.T) {
+	t.Run("The cache should follow the hosts of the service", func(t *testing.T) {
+		w1 := registerForTest(t, t.Context(), "test_cache", genHost("test-cache-1"), RegisterOptions{})
+		require.NoError(t, w1.WaitRegistration(t.Context()))
+
+		require.NoError(t, EnableCache(t.Context(), "test_cache", CacheOptions{}))
+		require.NotNil(t, cacheFor("test_cache"))
+
+		hosts, err := Get(t.Context(), "test_cache").All(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, []string{w1.UUID()}, hostUUIDs(hosts))
+
+		ctx, cancel := context.WithCancel(t.Context())
+		w2 := registerForTest(t, ctx, "test_cache", genHost("test-cache-2"), RegisterOptions{})
+		require.NoError(t, w2.WaitRegistration(t.Context()))
+		require.Eventually(t, func() bool {
+			hosts, err := Get(t.Context(), "test_cache").All(t.Context())
+			return err == nil && len(hosts) == 2
+		}, 3*time.Second, 10*time.Millisecond)
+
+		cancel()
+		require.Eventually(t, func() bool {
+			hosts, err := Get(t.Context(), "test_cache").All(t.Context())
+			return err == nil && len(hosts) == 1
+		}, 3*time.Second, 10*time.Millisecond)
+
+		s, err := Get(t.Context(), "test_cache").Service(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, "public.dev", s.Hostname)
+	})
+
+	t.Run("The cache should report the skipped nodes as the uncached queries do", func(t *testing.T) {
+		w := registerForTest(t, t.Context(), "test_cache_skipped", genHost("test-cache-skipped"), RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+		res, err := KAPI().Set(t.Context(), "/services/test_cache_skipped/corrupt", "{", nil)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_, _ = KAPI().Delete(t.Context(), "/services/test_cache_skipped/corrupt", nil)
+		})
+
+		s, err := Get(t.Context(), "test_cache_skipped").Service(t.Context())
+		require.NoError(t, err)
+		_, uncached, err := s.AllWithReport(t.Context(), QueryOptions{})
+		require.NoError(t, err)
+
+		require.NoError(t, EnableCache(t.Context(), "test_cache_skipped", CacheOptions{}))
+		_, cached, err := s.AllWithReport(t.Context(), QueryOptions{})
+		require.NoError(t, err)
+		require.Len(t, cached.Skipped, 1)
+		assert.Equal(t, res.Node.ModifiedIndex, cached.Skipped[0].Index)
+		assert.Equal(t, uncached.Skipped, cached.Skipped)
+	})
+
+	t.Run("The cache should be disabled once its context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		require.NoError(t, EnableCache(ctx, "test_cache_disabled", CacheOptions{}))
+		require.NotNil(t, cacheFor("test_cache_disabled"))
+
+		cancel()
+		require.Eventually(t, func() bool {
+			return cacheFor("test_cache_disabled") == nil
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Enabling the cache twice should return ErrCacheAlreadyEnabled until it is disabled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		require.NoError(t, EnableCache(ctx, "test_cache_twice", CacheOptions{}))
+		require.ErrorIs(t, EnableCache(t.Context(), "test_cache_twice", CacheOptions{}), ErrCacheAlreadyEnabled)
+
+		cancel()
+		require.Eventually(t, func() bool {
+			return cacheFor("test_cache_twice") == nil
+		}, time.Second, 10*time.Millisecond)
+		require.NoError(t, EnableCache(t.Context(), "test_cache_twice", CacheOptions{}))
+	})
+
+	t.Run("The callers should not be able to modify the cached hosts", func(t *testing.T) {
+		w := registerForTest(t, t.Context(), "test_cache_copy", genHost("test-cache-copy"), RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+		require.NoError(t, EnableCache(t.Context(), "test_cache_copy", CacheOptions{}))
+
+		hosts, err := Get(t.Context(), "test_cache_copy").All(t.Context())
+		require.NoError(t, err)
+		require.Len(t, hosts, 1)
+		hosts[0].Ports["http"] = "1"
+		hosts[0].PrivatePorts["http"] = "1"
+		s, err := Get(t.Context(), "test_cache_copy").Service(t.Context())
+		require.NoError(t, err)
+		s.Ports["http"] = "1"
+
+		hosts, err = Get(t.Context(), "test_cache_copy").All(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, "10000", hosts[0].Ports["http"])
+		assert.Equal(t, "20000", hosts[0].PrivatePorts["http"])
+		s, err = Get(t.Context(), "test_cache_copy").Service(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, "10000", s.Ports["http"])
+	})
+
+	t.Run("With a write between the reads, it should prime the cache from a snapshot at a single index", func(t *testing.T) {
+		var reads atomic.Int32
+		var watchesMutex sync.Mutex
+		watches := map[string]string{}
+		useFakeEtcdServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("wait") == "true" {
+				watchesMutex.Lock()
+				watches[r.URL.Path] = r.URL.Query().Get("waitIndex")
+				watchesMutex.Unlock()
+				<-r.Context().Done()
+				return
+			}
+
+			// Another key is written between the first and the second read
+			index := "11"
+			if reads.Add(1) == 1 {
+				index = "10"
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Etcd-Index", index)
+			var err error
+			if r.URL.Path == "/v2/keys/services_infos/test_cache_snapshot" {
+				_, err = w.Write([]byte(`{"action":"get","node":{"key":"/services_infos/test_cache_snapshot","value":"{\"name\":\"test_cache_snapshot\"}","modifiedIndex":1,"createdIndex":1}}`))
+			} else {
+				_, err = w.Write([]byte(`{"action":"get","node":{"key":"/services/test_cache_snapshot","dir":true,"nodes":[],"modifiedIndex":1,"createdIndex":1}}`))
+			}
+			assert.NoError(t, err)
+		})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		require.NoError(t, EnableCache(ctx, "test_cache_snapshot", CacheOptions{}))
+
+		assert.Equal(t, int32(3), reads.Load())
+		require.Eventually(t, func() bool {
+			watchesMutex.Lock()
+			defer watchesMutex.Unlock()
+			return len(watches) == 2
+		}, time.Second, 10*time.Millisecond)
+		watchesMutex.Lock()
+		defer watchesMutex.Unlock()
+		assert.Equal(t, map[string]string{
+			"/v2/keys/services_infos/test_cache_snapshot": "12",
+			"/v2/keys/services/test_cache_snapshot":       "12",
+		}, watches)
+	})
+
+	t.Run("During an etcd outage, the cache should be served up to its max age", func(t *testing.T) {
+		var failing atomic.Bool
+		useFakeEtcdServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("wait") == "true" {
+				for !failing.Load() {
+					select {
+					case <-r.Context().Done():
+						return
+					case <-time.After(10 * time.Millisecond):
+					}
+				}
+			}
+			if failing.Load() {
+				writeEtcdError(t, w)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Etcd-Index", "10")
+			var err error
+			if r.URL.Path == "/v2/keys/services_infos/test_cache_outage" {
+				_, err = w.Write([]byte(`{"action":"get","node":{"key":"/services_infos/test_cache_outage","value":"{\"name\":\"test_cache_outage\"}","modifiedIndex":1,"createdIndex":1}}`))
+			} else {
+				_, err = w.Write([]byte(`{"action":"get","node":{"key":"/services/test_cache_outage","dir":true,"nodes":[{"key":"/services/test_cache_outage/host-1","value":"{\"name\":\"host-1\",\"uuid\":\"host-1\"}","modifiedIndex":2,"createdIndex":2}],"modifiedIndex":1,"createdIndex":1}}`))
+			}
+			assert.NoError(t, err)
+		})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		require.NoError(t, EnableCache(ctx, "test_cache_outage", CacheOptions{MaxAge: 500 * time.Millisecond}))
+
+		failing.Store(true)
+		time.Sleep(100 * time.Millisecond)
+		hosts, err := Get(t.Context(), "test_cache_outage").All(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"host-1"}, hostUUIDs(hosts))
+
+		time.Sleep(500 * time.Millisecond)
+		_, err = Get(t.Context(), "test_cache_outage").All(t.Context())
+		require.Error(t, err)
+	})
+}
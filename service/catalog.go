@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"path"
+	"slices"
+	"sort"
+
+	etcdv2 "go.etcd.io/etcd/client/v2"
+
+	"github.com/Scalingo/go-utils/errors/v3"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// ServiceSummary describes a service of the catalog returned by ListServices.
+type ServiceSummary struct {
+	Name string
+	// Critical and Public come from the service infos, or from the hosts of a legacy service
+	Critical bool
+	Public   bool
+	// HasInfos is false for the legacy services, which have hosts but no service infos
+	HasInfos bool
+	// HostCount is the number of hosts currently registered
+	HostCount int
+	// Shards are the shards of the registered hosts, sorted
+	Shards []string
+	// NewestHost is the most recently registered host, or nil if the service has no host
+	NewestHost *Host
+}
+
+// ListServices returns all the services of /services_infos and /services, sorted by name. This includes
+// the legacy services which have hosts but no service infos, and the services whose hosts are all gone.
+func ListServices(ctx context.Context) ([]ServiceSummary, error) {
+	log := logger.Get(ctx)
+	summaries := map[string]*ServiceSummary{}
+	summary := func(name string) *ServiceSummary {
+		if summaries[name] == nil {
+			summaries[name] = &ServiceSummary{Name: name}
+		}
+		return summaries[name]
+	}
+
+	res, err := KAPI().Get(ctx, "/services_infos", nil)
+	if err != nil && !isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+		return nil, errors.Wrap(ctx, err, "get services infos")
+	}
+	if err == nil {
+		for _, node := range res.Node.Nodes {
+			service, err := buildServiceFromNode(ctx, node)
+			if err != nil {
+				log.WithError(err).Errorf("Invalid service infos '%s'", node.Key)
+				summary(path.Base(node.Key))
+				continue
+			}
+			s := summary(path.Base(node.Key))
+			s.HasInfos = true
+			s.Critical = service.Critical
+			s.Public = service.Public
+		}
+	}
+
+	res, err = KAPI().Get(ctx, "/services", &etcdv2.GetOptions{Recursive: true})
+	if err != nil && !isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+		return nil, errors.Wrap(ctx, err, "get services hosts")
+	}
+	if err == nil {
+		for _, serviceNode := range res.Node.Nodes {
+			if !serviceNode.Dir {
+				continue
+			}
+			s := summary(path.Base(serviceNode.Key))
+			var newestIndex uint64
+			for _, node := range serviceNode.Nodes {
+				host, err := buildHostFromNode(ctx, node)
+				if err != nil {
+					log.WithError(err).Errorf("Invalid host '%s'", node.Key)
+					continue
+				}
+				s.HostCount++
+				if host.Shard != "" && !slices.Contains(s.Shards, host.Shard) {
+					s.Shards = append(s.Shards, host.Shard)
+				}
+				if !s.HasInfos {
+					s.Critical = s.Critical || host.Critical
+					s.Public = s.Public || host.Public
+				}
+				// The heartbeats reset the created index of the host keys, it only breaks the ties between
+				// the hosts registered at the same time or by older versions.
+				newer := s.NewestHost == nil || host.RegisteredAt.After(s.NewestHost.RegisteredAt) ||
+					host.RegisteredAt.Equal(s.NewestHost.RegisteredAt) && node.CreatedIndex > newestIndex
+				if newer {
+					s.NewestHost = host
+					newestIndex = node.CreatedIndex
+				}
+			}
+			slices.Sort(s.Shards)
+		}
+	}
+
+	list := make([]ServiceSummary, 0, len(summaries))
+	for _, s := range summaries {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func findServiceSummary(t *testing.T, list []ServiceSummary, name string) ServiceSummary {
+	t.Helper()
+	for _, s := range list {
+		if s.Name == name {
+			return s
+		}
+	}
+	require.Failf(t, "service not listed", "%s", name)
+	return ServiceSummary{}
+}
+
+func TestListServices(t *testing.T) {
+	host1 := genHost("test-catalog-1")
+	host1.Critical = false
+	host1.Shard = testShard2ID
+	// The first host is refreshed after the registration of the second one
+	w1 := registerForTest(t, t.Context(), "test_catalog", host1, RegisterOptions{RefreshInterval: 200 * time.Millisecond})
+	require.NoError(t, w1.WaitRegistration(t.Context()))
+	host2 := genHost("test-catalog-2")
+	host2.Critical = false
+	host2.Shard = testShard1ID
+	w2 := registerForTest(t, t.Context(), "test_catalog", host2, RegisterOptions{})
+	require.NoError(t, w2.WaitRegistration(t.Context()))
+
+	legacyHost := genHost("test-catalog-legacy")
+	legacyHost.Public = false
+	legacyJSON, err := json.Marshal(legacyHost)
+	require.NoError(t, err)
+	_, err = KAPI().Set(t.Context(), "/services/test_catalog_legacy/legacy-1", string(legacyJSON), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, _ = KAPI().Delete(t.Context(), "/services/test_catalog_legacy/legacy-1", nil)
+	})
+
+	time.Sleep(500 * time.Millisecond)
+
+	list, err := ListServices(t.Context())
+	require.NoError(t, err)
+	for i := 1; i < len(list); i++ {
+		assert.Less(t, list[i-1].Name, list[i].Name)
+	}
+
+	s := findServiceSummary(t, list, "test_catalog")
+	assert.True(t, s.HasInfos)
+	assert.True(t, s.Public)
+	assert.False(t, s.Critical)
+	assert.Equal(t, 2, s.HostCount)
+	assert.Equal(t, []string{testShard1ID, testShard2ID}, s.Shards)
+	require.NotNil(t, s.NewestHost)
+	assert.Equal(t, w2.UUID(), s.NewestHost.UUID)
+
+	legacy := findServiceSummary(t, list, "test_catalog_legacy")
+	assert.False(t, legacy.HasInfos)
+	assert.True(t, legacy.Critical)
+	assert.False(t, legacy.Public)
+	assert.Equal(t, 1, legacy.HostCount)
+	assert.Empty(t, legacy.Shards)
+}
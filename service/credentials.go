@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	etcdv2 "go.etcd.io/etcd/client/v2"
+
+	"github.com/Scalingo/go-utils/errors/v3"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// hostCredentialsTTL is the TTL of the credentials written by RotateHostCredentials, long enough for the
+// registration of the host to pick them up. The registration then refreshes them with the TTL of its host
+// key, so that they disappear along with the host.
+const hostCredentialsTTL = time.Minute
+
+// RotateHostCredentials sets new credentials for a single host of a service whose credentials are
+// stored per host, see CredentialsScopeHost.
+//
+// The credentials are written to /services_credentials/<name>/<uuid>. The registration of this host
+// updates its host key with these credentials, and sends them to its Registration. The credentials expire
+// once the registration of the host stops refreshing them. Empty credentials are rejected with
+// ErrEmptyCredentials.
+func RotateHostCredentials(ctx context.Context, service, uuid string, credentials Credentials) error {
+	if credentials == (Credentials{}) {
+		return ErrEmptyCredentials
+	}
+
+	res, err := KAPI().Get(ctx, fmt.Sprintf("/services/%s/%s", service, uuid), nil)
+	if err != nil {
+		if etcdv2.IsKeyNotFound(err) {
+			return ErrNoHostFound
+		}
+		return errors.Wrap(ctx, err, "get host")
+	}
+
+	host, err := buildHostFromNode(ctx, res.Node)
+	if err != nil {
+		return errors.Wrap(ctx, err, "build host from node")
+	}
+	if host.CredentialsScope.withDefault(host.Public) == CredentialsScopeService {
+		return ErrSharedCredentials
+	}
+
+	credentialsJSON, _ := json.Marshal(credentials)
+	_, err = KAPI().Set(ctx, hostCredentialsKey(service, uuid), string(credentialsJSON), &etcdv2.SetOptions{TTL: hostCredentialsTTL})
+	if err != nil {
+		return errors.Wrap(ctx, err, "set host credentials")
+	}
+	return nil
+}
+
+// RotateServiceCredentials sets new credentials for a service whose credentials are shared by all its
+// hosts, see CredentialsScopeService.
+//
+// The credentials are written to /services_infos/<name>. The registrations of all the hosts of the
+// service update their host key with these credentials, and send them to their Registration. Empty
+// credentials are rejected with ErrEmptyCredentials.
+func RotateServiceCredentials(ctx context.Context, service string, credentials Credentials) error {
+	if credentials == (Credentials{}) {
+		return ErrEmptyCredentials
+	}
+
+	serviceKey := fmt.Sprintf("/services_infos/%s", service)
+
+	for {
+		res, err := KAPI().Get(ctx, serviceKey, nil)
+		if err != nil {
+			if etcdv2.IsKeyNotFound(err) {
+				return ErrNoServiceFound
+			}
+			return errors.Wrap(ctx, err, "get service infos")
+		}
+
+		serviceInfos, err := buildServiceFromNode(ctx, res.Node)
+		if err != nil {
+			return errors.Wrap(ctx, err, "build service from node")
+		}
+		if serviceInfos.CredentialsScope.withDefault(serviceInfos.Public) != CredentialsScopeService {
+			return ErrPerHostCredentials
+		}
+
+		serviceInfos.User = credentials.User
+		serviceInfos.Password = credentials.Password
+		serviceJSON, _ := json.Marshal(serviceInfos)
+
+		// The compare-and-swap prevents overwriting a concurrent modification of the service infos.
+		_, err = KAPI().Set(ctx, serviceKey, string(serviceJSON), &etcdv2.SetOptions{
+			PrevIndex: res.Node.ModifiedIndex,
+		})
+		if isEtcdError(err, etcdv2.ErrorCodeTestFailed) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(ctx, err, "set service credentials")
+		}
+		return nil
+	}
+}
+
+// RotateCredentials sets new credentials for the registered host. If the credentials are shared by all the
+// hosts of the service, the credentials of the service are rotated, see RotateServiceCredentials.
+// Otherwise, only the credentials of this host are rotated, see RotateHostCredentials.
+func (w *Registration) RotateCredentials(ctx context.Context, credentials Credentials) error {
+	if w.sharedCredentials {
+		err := RotateServiceCredentials(ctx, w.service, credentials)
+		if err != nil {
+			return errors.Wrap(ctx, err, "rotate service credentials")
+		}
+		return nil
+	}
+
+	err := RotateHostCredentials(ctx, w.service, w.uuid, credentials)
+	if err != nil {
+		return errors.Wrap(ctx, err, "rotate host credentials")
+	}
+	return nil
+}
+
+func hostCredentialsKey(service, uuid string) string {
+	return fmt.Sprintf("/services_credentials/%s/%s", service, uuid)
+}
+
+// refreshHostCredentials refreshes the TTL of the credentials of a host, if they have been rotated. The
+// refresh does not notify the watchers of the key.
+func refreshHostCredentials(ctx context.Context, credentialsKey string, ttl time.Duration) error {
+	_, err := KAPI().Set(ctx, credentialsKey, "", &etcdv2.SetOptions{
+		TTL:     ttl,
+		Refresh: true,
+	})
+	if err != nil && !etcdv2.IsKeyNotFound(err) {
+		return errors.Wrap(ctx, err, "refresh host credentials")
+	}
+	return nil
+}
+
+// watchHostCredentials watches the credentials of a single host, written by RotateHostCredentials,
+// and sends them to the register agent. credentials are the credentials currently used by the
+// registration.
+func watchHostCredentials(ctx context.Context, credentialsKey string, id uint64, credentials Credentials, credentialsChan chan Credentials) {
+	log := logger.Get(ctx)
+
+	resync := false
+	for {
+		if resync {
+			// Rotations may have been missed while the watcher was lost, read the current credentials.
+			index, currentCredentials, err := getHostCredentials(ctx, credentialsKey)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				log.WithError(err).Errorf("Fail to resync the watcher of '%s' (%v)", credentialsKey, Client().Endpoints())
+				if sleepOrDone(ctx, 1*time.Second) != nil {
+					return
+				}
+				continue
+			}
+			id = index
+			resync = false
+
+			if currentCredentials != (Credentials{}) && currentCredentials != credentials {
+				credentials = currentCredentials
+				select {
+				case <-ctx.Done():
+					return
+				case credentialsChan <- credentials:
+				}
+			}
+		}
+
+		watcher := KAPI().Watcher(credentialsKey, &etcdv2.WatcherOptions{
+			AfterIndex: id,
+		})
+		resp, err := watcher.Next(ctx)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		if err != nil {
+			// We've lost the connexion to etcd, or the index we're watching from has been cleared. Sleep 1s and retry
+			log.WithError(err).Errorf("Lost watcher of '%s' (%v)", credentialsKey, Client().Endpoints())
+			resync = true
+			if sleepOrDone(ctx, 1*time.Second) != nil {
+				return
+			}
+			continue
+		}
+		id = resp.Node.ModifiedIndex
+
+		if resp.Action == "delete" || resp.Action == "expire" {
+			continue
+		}
+
+		var newCredentials Credentials
+		err = json.Unmarshal([]byte(resp.Node.Value), &newCredentials)
+		if err != nil || newCredentials == (Credentials{}) {
+			log.WithError(err).Errorf("Invalid host credentials key '%s'", credentialsKey)
+			continue
+		}
+		credentials = newCredentials
+
+		select {
+		case <-ctx.Done():
+			return
+		case credentialsChan <- credentials:
+		}
+	}
+}
+
+// getHostCredentials returns the credentials of a host, and the etcd index to watch the following
+// modifications from. The credentials are empty if they are missing or invalid.
+func getHostCredentials(ctx context.Context, credentialsKey string) (uint64, Credentials, error) {
+	res, err := KAPI().Get(ctx, credentialsKey, nil)
+	if err != nil {
+		var etcdErr etcdv2.Error
+		if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+			return etcdErr.Index, Credentials{}, nil
+		}
+		return 0, Credentials{}, errors.Wrap(ctx, err, "get host credentials")
+	}
+
+	var credentials Credentials
+	err = json.Unmarshal([]byte(res.Node.Value), &credentials)
+	if err != nil {
+		logger.Get(ctx).WithError(err).Errorf("Invalid host credentials key '%s'", credentialsKey)
+		return res.Index, Credentials{}, nil
+	}
+	return res.Index, credentials, nil
+}
+
+// serviceRegistrationWithCredentials registers the service infos, keeping the credentials already stored
+// in etcd. If the service does not have any credentials yet, new credentials are generated.
+//
+// All the writes are conditional: when several hosts start concurrently, a single one writes its
+// generated credentials and all the others adopt them. serviceInfos is updated with the credentials of
+// the service.
+func serviceRegistrationWithCredentials(ctx context.Context, serviceKey string, serviceInfos *Service) (uint64, error) {
+	for {
+		res, err := KAPI().Get(ctx, serviceKey, nil)
+		if err != nil && !etcdv2.IsKeyNotFound(err) {
+			return 0, errors.Wrap(ctx, err, "get service infos")
+		}
+
+		credentials := Credentials{}
+		setOpts := &etcdv2.SetOptions{PrevExist: etcdv2.PrevNoExist}
+		if err == nil {
+			setOpts = &etcdv2.SetOptions{PrevIndex: res.Node.ModifiedIndex}
+			currentInfos, err := buildServiceFromNode(ctx, res.Node)
+			if err == nil {
+				credentials = Credentials{User: currentInfos.User, Password: currentInfos.Password}
+			}
+		}
+		if credentials == (Credentials{}) {
+			credentials = generateCredentials()
+		}
+
+		serviceInfos.User = credentials.User
+		serviceInfos.Password = credentials.Password
+		serviceJSON, _ := json.Marshal(serviceInfos)
+
+		key, err := KAPI().Set(ctx, serviceKey, string(serviceJSON), setOpts)
+		if isEtcdError(err, etcdv2.ErrorCodeNodeExist) || isEtcdError(err, etcdv2.ErrorCodeTestFailed) {
+			// Another host registered the service in the meantime, adopt its credentials.
+			continue
+		}
+		if err != nil {
+			return 0, errors.Wrap(ctx, err, "register service")
+		}
+		return key.Node.ModifiedIndex, nil
+	}
+}
+
+// generateCredentials generates a random user and password.
+func generateCredentials() Credentials {
+	return Credentials{
+		User:     rand.Text(),
+		Password: rand.Text(),
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"time"
+
+	etcdv2 "go.etcd.io/etcd/client/v2"
+
+	"github.com/Scalingo/go-utils/errors/v3"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// evictedMarkerTTL is how long an eviction marker is kept. It only has to outlive the evicted
+// registration, which stops as soon as it notices the removal of its host key.
+const evictedMarkerTTL = 1 * time.Hour
+
+// ErrEvicted is the error returned by Registration.Err once the host has been evicted with Evict.
+var ErrEvicted = stderrors.New("the host has been evicted")
+
+// Evict removes a host from a service. Without an eviction, a registration re-creates its host key
+// as soon as it is removed. The registration of an evicted host stops, and its Err method returns
+// ErrEvicted.
+func Evict(ctx context.Context, service, uuid string) error {
+	hostKey := fmt.Sprintf("/services/%s/%s", service, uuid)
+	_, err := KAPI().Get(ctx, hostKey, nil)
+	if err != nil {
+		if isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+			return ErrNoHostFound
+		}
+		return errors.Wrap(ctx, err, "get host")
+	}
+
+	// The marker must be written first, so that the registration finds it once its host key is removed
+	_, err = KAPI().Set(ctx, evictedKey(service, uuid), "", &etcdv2.SetOptions{TTL: evictedMarkerTTL})
+	if err != nil {
+		return errors.Wrap(ctx, err, "mark host as evicted")
+	}
+
+	_, err = KAPI().Delete(ctx, hostKey, nil)
+	if err != nil && !isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+		return errors.Wrap(ctx, err, "remove host")
+	}
+	return nil
+}
+
+func evictedKey(service, uuid string) string {
+	return fmt.Sprintf("/services_evicted/%s/%s", service, uuid)
+}
+
+// watchHostKey notifies checkChan whenever the host key may have been removed or modified by another
+// writer. The heartbeats of the registration, which rewrite the same value, are ignored.
+func watchHostKey(ctx context.Context, hostKey string, id uint64, checkChan chan struct{}) {
+	log := logger.Get(ctx)
+
+	resync := false
+	for {
+		if resync {
+			// Modifications may have been missed while the watcher was lost
+			res, err := KAPI().Get(ctx, hostKey, nil)
+			var etcdErr etcdv2.Error
+			switch {
+			case err == nil:
+				id = res.Index
+			case errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound:
+				id = etcdErr.Index
+			case errors.Is(err, context.Canceled):
+				return
+			default:
+				log.WithError(err).Errorf("Fail to resync the watcher of '%s' (%v)", hostKey, Client().Endpoints())
+				if sleepOrDone(ctx, 1*time.Second) != nil {
+					return
+				}
+				continue
+			}
+			resync = false
+			notify(checkChan)
+		}
+
+		watcher := KAPI().Watcher(hostKey, &etcdv2.WatcherOptions{
+			AfterIndex: id,
+		})
+		resp, err := watcher.Next(ctx)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if err != nil {
+			log.WithError(err).Errorf("Lost watcher of '%s' (%v)", hostKey, Client().Endpoints())
+			resync = true
+			if sleepOrDone(ctx, 1*time.Second) != nil {
+				return
+			}
+			continue
+		}
+		id = resp.Node.ModifiedIndex
+
+		if resp.PrevNode != nil && resp.Node.Value == resp.PrevNode.Value &&
+			resp.Action != "delete" && resp.Action != "compareAndDelete" && resp.Action != "expire" {
+			// Heartbeat of the registration
+			continue
+		}
+		notify(checkChan)
+	}
+}
+
+// checkHostKey compares the host key stored in etcd with the value written by the registration. It
+// returns an empty event type if the host key is up to date.
+func checkHostKey(ctx context.Context, service, uuid, hostKey, hostJSON string) (RegistrationEventType, error) {
+	// The eviction marker is checked first: the host key may already have been re-created by a heartbeat
+	_, err := KAPI().Get(ctx, evictedKey(service, uuid), nil)
+	if err == nil {
+		return RegistrationEventEvicted, nil
+	}
+	if !isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+		return "", errors.Wrap(ctx, err, "get eviction marker")
+	}
+
+	res, err := KAPI().Get(ctx, hostKey, nil)
+	if isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+		return RegistrationEventDeleted, nil
+	}
+	if err != nil {
+		return "", errors.Wrap(ctx, err, "get host key")
+	}
+	if res.Node.Value != hostJSON {
+		return RegistrationEventOverwritten, nil
+	}
+	return "", nil
+}
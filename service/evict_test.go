@@ -0,0 +1,116 @@
+package service
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	etcdv2 "go.etcd.io/etcd/client/v2"
+)
+
+func TestHostKeyWatch(t *testing.T) {
+	host := genHost("test-host-key-watch")
+	w := registerForTest(t, t.Context(), "test_host_key_watch", host, RegisterOptions{})
+	require.NoError(t, w.WaitRegistration(t.Context()))
+	hostKey := "/services/test_host_key_watch/" + w.UUID()
+
+	res, err := KAPI().Get(t.Context(), hostKey, nil)
+	require.NoError(t, err)
+	hostJSON := res.Node.Value
+
+	t.Run("When the host key is removed, it should be re-registered immediately", func(t *testing.T) {
+		_, err := KAPI().Delete(t.Context(), hostKey, nil)
+		require.NoError(t, err)
+
+		assertRegistrationEvent(t, w, RegistrationEventDeleted)
+		res, err := KAPI().Get(t.Context(), hostKey, nil)
+		require.NoError(t, err)
+		assert.Equal(t, hostJSON, res.Node.Value)
+	})
+
+	t.Run("When the host key is overwritten, it should be re-registered immediately", func(t *testing.T) {
+		_, err := KAPI().Set(t.Context(), hostKey, `{"name":"other"}`, nil)
+		require.NoError(t, err)
+
+		assertRegistrationEvent(t, w, RegistrationEventOverwritten)
+		res, err := KAPI().Get(t.Context(), hostKey, nil)
+		require.NoError(t, err)
+		assert.Equal(t, hostJSON, res.Node.Value)
+		assert.True(t, w.Ready())
+	})
+}
+
+func TestEvict(t *testing.T) {
+	t.Run("With a registered host, it should remove it and stop its registration", func(t *testing.T) {
+		host := genHost("test-evict")
+		w := registerForTest(t, t.Context(), "test_evict", host, RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		require.NoError(t, Evict(t.Context(), "test_evict", w.UUID()))
+
+		select {
+		case <-w.Done():
+		case <-time.After(3 * time.Second):
+			t.Fatal("the registration has not stopped")
+		}
+		// The host may have been re-registered once before the registration noticed the eviction
+		var lastEvent RegistrationEvent
+		for len(w.Events()) > 0 {
+			lastEvent = <-w.Events()
+		}
+		assert.Equal(t, RegistrationEventEvicted, lastEvent.Type)
+		require.ErrorIs(t, w.Err(), ErrEvicted)
+		assert.False(t, w.Ready())
+
+		_, err := KAPI().Get(t.Context(), "/services/test_evict/"+w.UUID(), nil)
+		assert.True(t, isEtcdError(err, etcdv2.ErrorCodeKeyNotFound))
+	})
+
+	t.Run("With a registered host, its watchers should stop along with its registration", func(t *testing.T) {
+		host := genHost("test-evict-watchers")
+		host.Public = false
+		w := registerForTest(t, t.Context(), "test_evict_watchers", host, RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		require.NoError(t, Evict(t.Context(), "test_evict_watchers", w.UUID()))
+		select {
+		case <-w.Done():
+		case <-time.After(3 * time.Second):
+			t.Fatal("the registration and its watchers have not stopped")
+		}
+		require.ErrorIs(t, w.Err(), ErrEvicted)
+
+		assert.Zero(t, runningRegistrationWatchers())
+	})
+
+	t.Run("With an unknown host, it should return ErrNoHostFound", func(t *testing.T) {
+		err := Evict(t.Context(), "test_evict", "unknown")
+		require.ErrorIs(t, err, ErrNoHostFound)
+	})
+}
+
+func assertRegistrationEvent(t *testing.T, w *Registration, eventType RegistrationEventType) {
+	t.Helper()
+
+	select {
+	case event := <-w.Events():
+		assert.Equal(t, eventType, event.Type)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no %s event received", eventType)
+	}
+}
+
+// runningRegistrationWatchers returns the number of goroutines running the watchers started by Register
+func runningRegistrationWatchers() int {
+	buf := make([]byte, 1<<20)
+	stacks := string(buf[:runtime.Stack(buf, true)])
+
+	count := 0
+	for _, watcher := range []string{"service.watch(", "service.watchHostCredentials(", "service.watchHostKey("} {
+		count += strings.Count(stacks, watcher)
+	}
+	return count
+}
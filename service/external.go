@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+
+	etcdv2 "go.etcd.io/etcd/client/v2"
+
+	"github.com/Scalingo/go-utils/errors/v3"
+)
+
+// ErrNotExternal is returned when an external host operation targets a host maintained by Register.
+var ErrNotExternal = stderrors.New("the host is not an external host")
+
+// RegisterExternal creates a permanent host entry for a dependency which cannot run the Register heartbeat,
+// like a managed database or a third-party API. The entry has no TTL and is flagged as External: it is
+// returned by Service.All and URL like any other host, until it is removed with DeregisterExternal.
+//
+// If host.UUID is set, the entry with this UUID is created or replaced. Otherwise a new UUID is generated.
+// The PrivateHostname defaults to the Hostname. The service infos are created, or updated with the fields
+// of the host, keeping the credentials they store if the host has none.
+func RegisterExternal(ctx context.Context, service string, host Host) (*Host, error) {
+	err := host.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	uuid := host.UUID
+	if host.PrivateHostname == "" {
+		// The hostname of the machine creating the entry has nothing to do with the external host
+		host.PrivateHostname = host.Hostname
+	}
+	host = prepareHost(service, host)
+	if uuid != "" {
+		host.UUID = uuid
+	}
+	host.External = true
+	host.GenerateCredentials = false
+
+	hostKey := fmt.Sprintf("/services/%s/%s", service, host.UUID)
+	res, err := KAPI().Get(ctx, hostKey, nil)
+	if err != nil && !isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+		return nil, errors.Wrap(ctx, err, "get host")
+	}
+	setOpts := &etcdv2.SetOptions{PrevExist: etcdv2.PrevNoExist}
+	if err == nil {
+		current, err := buildHostFromNode(ctx, res.Node)
+		if err == nil && !current.External {
+			return nil, ErrNotExternal
+		}
+		setOpts = &etcdv2.SetOptions{PrevIndex: res.Node.ModifiedIndex}
+	}
+
+	serviceInfos := &Service{
+		Name:             service,
+		Critical:         host.Critical,
+		Public:           host.Public,
+		CredentialsScope: host.CredentialsScope,
+	}
+	if host.Public {
+		serviceInfos.Hostname = host.Hostname
+		serviceInfos.Ports = host.Ports
+	}
+	if host.CredentialsScope.withDefault(host.Public) == CredentialsScopeService {
+		serviceInfos.User = host.User
+		serviceInfos.Password = host.Password
+	}
+	err = externalServiceRegistration(ctx, "/services_infos/"+service, serviceInfos)
+	if err != nil {
+		return nil, errors.Wrap(ctx, err, "register service")
+	}
+
+	hostJSON, _ := json.Marshal(&host)
+	_, err = KAPI().Set(ctx, hostKey, string(hostJSON), setOpts)
+	if isEtcdError(err, etcdv2.ErrorCodeNodeExist) || isEtcdError(err, etcdv2.ErrorCodeTestFailed) {
+		return nil, errors.Wrap(ctx, err, "the host has been modified concurrently")
+	}
+	if err != nil {
+		return nil, errors.Wrap(ctx, err, "register external host")
+	}
+	return &host, nil
+}
+
+// DeregisterExternal removes an external host entry created with RegisterExternal. It returns ErrNotExternal
+// if the host is maintained by Register.
+func DeregisterExternal(ctx context.Context, service, uuid string) error {
+	hostKey := fmt.Sprintf("/services/%s/%s", service, uuid)
+	res, err := KAPI().Get(ctx, hostKey, nil)
+	if isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+		return ErrNoHostFound
+	}
+	if err != nil {
+		return errors.Wrap(ctx, err, "get host")
+	}
+
+	host, err := buildHostFromNode(ctx, res.Node)
+	if err == nil && !host.External {
+		return ErrNotExternal
+	}
+
+	_, err = KAPI().Delete(ctx, hostKey, &etcdv2.DeleteOptions{PrevIndex: res.Node.ModifiedIndex})
+	if err != nil {
+		return errors.Wrap(ctx, err, "remove external host")
+	}
+	return nil
+}
+
+// externalServiceRegistration creates or updates the service infos of an external host. The shared
+// credentials already stored are kept if serviceInfos has none. The write is a compare-and-swap, so that a concurrent
+// modification of the service infos is never overwritten.
+func externalServiceRegistration(ctx context.Context, serviceKey string, serviceInfos *Service) error {
+	for {
+		res, err := KAPI().Get(ctx, serviceKey, nil)
+		if err != nil && !isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+			return errors.Wrap(ctx, err, "get service infos")
+		}
+
+		updatedInfos := *serviceInfos
+		setOpts := &etcdv2.SetOptions{PrevExist: etcdv2.PrevNoExist}
+		if err == nil {
+			setOpts = &etcdv2.SetOptions{PrevIndex: res.Node.ModifiedIndex}
+			currentInfos, err := buildServiceFromNode(ctx, res.Node)
+			shared := updatedInfos.CredentialsScope.withDefault(updatedInfos.Public) == CredentialsScopeService
+			if err == nil && shared && updatedInfos.User == "" && updatedInfos.Password == "" {
+				updatedInfos.User = currentInfos.User
+				updatedInfos.Password = currentInfos.Password
+			}
+		}
+
+		serviceJSON, _ := json.Marshal(&updatedInfos)
+		_, err = KAPI().Set(ctx, serviceKey, string(serviceJSON), setOpts)
+		if isEtcdError(err, etcdv2.ErrorCodeNodeExist) || isEtcdError(err, etcdv2.ErrorCodeTestFailed) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(ctx, err, "set service infos")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	etcdv2 "go.etcd.io/etcd/client/v2"
+)
+
+func TestRegisterExternal(t *testing.T) {
+	host := Host{
+		Hostname: "db.example.com",
+		Ports:    Ports{"postgresql": "5432"},
+		User:     "user",
+		Password: "password",
+	}
+
+	external, err := RegisterExternal(t.Context(), "test_external", host)
+	require.NoError(t, err)
+	hostKey := "/services/test_external/" + external.UUID
+
+	t.Run("The external host should be returned like the other hosts", func(t *testing.T) {
+		w := registerForTest(t, t.Context(), "test_external", genHost("test-external"), RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		hosts, err := Get(t.Context(), "test_external").All(t.Context())
+		require.NoError(t, err)
+		require.Len(t, hosts, 2)
+
+		h, err := Get(t.Context(), "test_external").Service(t.Context())
+		require.NoError(t, err)
+		hosts, err = h.All(t.Context(), QueryOptions{})
+		require.NoError(t, err)
+		for _, h := range hosts {
+			if h.UUID == external.UUID {
+				assert.True(t, h.External)
+				assert.Equal(t, "db.example.com", h.PrivateHostname)
+			} else {
+				assert.False(t, h.External)
+			}
+		}
+	})
+
+	t.Run("The external host should not expire", func(t *testing.T) {
+		res, err := KAPI().Get(t.Context(), hostKey, nil)
+		require.NoError(t, err)
+		assert.Nil(t, res.Node.Expiration)
+	})
+
+	t.Run("The external host should be replaced with its UUID", func(t *testing.T) {
+		host.UUID = external.UUID
+		host.Ports = Ports{"postgresql": "5433"}
+		replaced, err := RegisterExternal(t.Context(), "test_external", host)
+		require.NoError(t, err)
+		assert.Equal(t, external.UUID, replaced.UUID)
+		assert.Equal(t, Ports{"postgresql": "5433"}, replaced.PrivatePorts)
+	})
+
+	t.Run("The service infos should be updated with the replaced host", func(t *testing.T) {
+		s, err := Get(t.Context(), "test_external").Service(t.Context())
+		require.NoError(t, err)
+		assert.False(t, s.Public)
+
+		host.Public = true
+		host.User = ""
+		host.Password = ""
+		_, err = RegisterExternal(t.Context(), "test_external", host)
+		require.NoError(t, err)
+
+		s, err = Get(t.Context(), "test_external").Service(t.Context())
+		require.NoError(t, err)
+		assert.True(t, s.Public)
+		assert.Equal(t, "db.example.com", s.Hostname)
+		assert.Equal(t, Ports{"postgresql": "5433"}, s.Ports)
+	})
+
+	t.Run("A public host without PrivateHostname should use its Hostname", func(t *testing.T) {
+		public, err := RegisterExternal(t.Context(), "test_external_public", Host{
+			Hostname: "api.example.com",
+			Ports:    Ports{"https": "443"},
+			Public:   true,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "api.example.com", public.PrivateHostname)
+		assert.True(t, strings.HasSuffix(public.UUID, "-api.example.com"))
+		require.NoError(t, DeregisterExternal(t.Context(), "test_external_public", public.UUID))
+	})
+
+	t.Run("A host maintained by Register should not be modified", func(t *testing.T) {
+		w := registerForTest(t, t.Context(), "test_external", genHost("test-external-registered"), RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		_, err := RegisterExternal(t.Context(), "test_external", Host{Hostname: "other", UUID: w.UUID()})
+		require.ErrorIs(t, err, ErrNotExternal)
+		err = DeregisterExternal(t.Context(), "test_external", w.UUID())
+		require.ErrorIs(t, err, ErrNotExternal)
+	})
+
+	t.Run("The external host should be removed with DeregisterExternal", func(t *testing.T) {
+		require.NoError(t, DeregisterExternal(t.Context(), "test_external", external.UUID))
+		_, err := KAPI().Get(t.Context(), hostKey, nil)
+		assert.True(t, isEtcdError(err, etcdv2.ErrorCodeKeyNotFound))
+
+		err = DeregisterExternal(t.Context(), "test_external", external.UUID)
+		require.ErrorIs(t, err, ErrNoHostFound)
+	})
+}
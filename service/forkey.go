@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+
+	"github.com/Scalingo/go-utils/errors/v3"
+)
+
+// ForKey returns the host responsible for a routing key. The host is chosen with rendezvous hashing over the
+// host UUIDs: the same key lands on the same host while the hosts are stable, and only the keys of a host
+// which comes or goes are moved.
+func (s *Service) ForKey(ctx context.Context, key string, queryOpts QueryOptions) (*Host, error) {
+	host, _, err := s.ForKeyWithReport(ctx, key, queryOpts)
+	return host, err
+}
+
+// ForKeyWithReport is similar to ForKey, but also returns how the query has been answered.
+func (s *Service) ForKeyWithReport(ctx context.Context, key string, queryOpts QueryOptions) (*Host, QueryReport, error) {
+	hosts, report, err := s.AllWithReport(ctx, queryOpts)
+	if err != nil {
+		return nil, report, errors.Wrap(ctx, err, "fetch all hosts")
+	}
+
+	host := hosts.RankByKey(key)[0]
+	report.Shard = host.shard()
+	return host, report, nil
+}
+
+// RankByKey returns all the hosts of the service ordered by preference for a routing key. The first host is
+// the one returned by ForKey, the following ones are the fallbacks, or the replicas, of the key.
+func (s *Service) RankByKey(ctx context.Context, key string, queryOpts QueryOptions) (Hosts, error) {
+	hosts, err := s.All(ctx, queryOpts)
+	if err != nil {
+		return nil, errors.Wrap(ctx, err, "fetch all hosts")
+	}
+	return hosts.RankByKey(key), nil
+}
+
+// RankByKey returns a copy of the hosts ordered by preference for a routing key, with rendezvous hashing.
+func (hs Hosts) RankByKey(key string) Hosts {
+	scores := make(map[string]uint64, len(hs))
+	for _, h := range hs {
+		scores[h.UUID] = rendezvousScore(key, h.UUID)
+	}
+
+	ranked := make(Hosts, len(hs))
+	copy(ranked, hs)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		si, sj := scores[ranked[i].UUID], scores[ranked[j].UUID]
+		if si != sj {
+			return si > sj
+		}
+		return ranked[i].UUID < ranked[j].UUID
+	})
+	return ranked
+}
+
+// rendezvousScore is the weight of a host for a key. The FNV hash is mixed with the SplitMix64 finalizer,
+// since FNV alone spreads close inputs poorly.
+func rendezvousScore(key, uuid string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(uuid))
+
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostsRankByKey(t *testing.T) {
+	hosts := genBalancerHosts("a", "b", "c", "d")
+
+	t.Run("The ranking should not depend on the order of the hosts", func(t *testing.T) {
+		ranked := hosts.RankByKey("my-key")
+		assert.Len(t, ranked, 4)
+		assert.Equal(t, hostUUIDs(ranked), hostUUIDs(genBalancerHosts("d", "c", "b", "a").RankByKey("my-key")))
+		assert.Equal(t, []string{"a", "b", "c", "d"}, hostUUIDs(hosts))
+	})
+
+	t.Run("The keys should be spread over the hosts", func(t *testing.T) {
+		owners := map[string]int{}
+		for i := range 1000 {
+			owners[hosts.RankByKey(fmt.Sprintf("key-%d", i))[0].UUID]++
+		}
+		for _, uuid := range []string{"a", "b", "c", "d"} {
+			assert.Greater(t, owners[uuid], 150, uuid)
+		}
+	})
+
+	t.Run("When a host leaves, only its keys should move, to their first fallback", func(t *testing.T) {
+		remaining := genBalancerHosts("a", "b", "d")
+		for i := range 1000 {
+			key := fmt.Sprintf("key-%d", i)
+			before := hosts.RankByKey(key)
+			after := remaining.RankByKey(key)
+			if before[0].UUID == "c" {
+				assert.Equal(t, before[1].UUID, after[0].UUID, key)
+			} else {
+				assert.Equal(t, before[0].UUID, after[0].UUID, key)
+			}
+		}
+	})
+}
+
+func TestServiceForKey(t *testing.T) {
+	host1 := genHost("test-for-key-1")
+	host1.Shard = testShard1ID
+	w1 := registerForTest(t, t.Context(), "test_service_for_key", host1, RegisterOptions{})
+	host2 := genHost("test-for-key-2")
+	host2.Shard = testShard1ID
+	w2 := registerForTest(t, t.Context(), "test_service_for_key", host2, RegisterOptions{})
+	host3 := genHost("test-for-key-3")
+	host3.Shard = testShard2ID
+	w3 := registerForTest(t, t.Context(), "test_service_for_key", host3, RegisterOptions{})
+	require.NoError(t, w1.WaitRegistration(t.Context()))
+	require.NoError(t, w2.WaitRegistration(t.Context()))
+	require.NoError(t, w3.WaitRegistration(t.Context()))
+
+	s, err := Get(t.Context(), "test_service_for_key").Service(t.Context())
+	require.NoError(t, err)
+
+	ranked, err := s.RankByKey(t.Context(), "my-key", QueryOptions{Shard: testShard1ID})
+	require.NoError(t, err)
+	require.Len(t, ranked, 2)
+	assert.ElementsMatch(t, []string{w1.UUID(), w2.UUID()}, hostUUIDs(ranked))
+
+	host, err := s.ForKey(t.Context(), "my-key", QueryOptions{Shard: testShard1ID})
+	require.NoError(t, err)
+	assert.Equal(t, ranked[0].UUID, host.UUID)
+
+	host, err = GetForShard(t.Context(), "test_service_for_key", testShard1ID).ForKey(t.Context(), "my-key").Host(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, ranked[0].UUID, host.UUID)
+
+	host, err = GetForShard(t.Context(), "test_service_for_key", testShard2ID).ForKey(t.Context(), "my-key").Host(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, w3.UUID(), host.UUID)
+}
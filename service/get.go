@@ -4,6 +4,8 @@ import (
 	"context"
 
 	etcdv2 "go.etcd.io/etcd/client/v2"
+
+	"github.com/Scalingo/go-utils/errors/v3"
 )
 
 // ServiceResponse is the interface used to provide a response to the service.Get()
@@ -22,12 +24,16 @@ type ServiceResponse interface {
 	Err() error
 	// Service returns the Service struct representing the requested service
 	Service(ctx context.Context) (*Service, error)
-	// One return a host of the service chosen randomly
+	// One return a host of the service chosen by the balancer of the query options, randomly by default
 	One(ctx context.Context) HostResponse
 	// First return the first host of the service
 	First(ctx context.Context) HostResponse
+	// ForKey returns the host responsible for a routing key, see Service.ForKey
+	ForKey(ctx context.Context, key string) HostResponse
 	// All returns all the hosts registered for this service
 	All(ctx context.Context) (Hosts, error)
+	// Shards returns all the hosts registered for this service grouped by shard, see Service.Shards
+	Shards(ctx context.Context) (map[string]Hosts, error)
 	// URL returns a valid url for this service
 	URL(ctx context.Context, scheme, path string) (string, error)
 }
@@ -44,22 +50,67 @@ type ServiceResponse interface {
 // If the service is not found, we won't render an error but will return a service with minimal
 // information. This is done to provide maximal backward compatibility since older versions do
 // not register themselves to the "/services_infos" directory.
+//
+// If the service is cached with EnableCache, etcd is not read.
+//
+// Use GetWithOptions to set the query options of the host-based operations.
 func Get(ctx context.Context, service string) ServiceResponse {
-	res, err := KAPI().Get(ctx, "/services_infos/"+service, nil)
+	return GetWithOptions(ctx, service, QueryOptions{})
+}
 
-	if err != nil {
-		if etcdv2.IsKeyNotFound(err) {
-			return &GetServiceResponse{
-				err: nil,
-				service: &Service{
-					Name: service,
-				},
+// GetForShard is similar to Get, but all host-based operations are filtered on the provided shard.
+func GetForShard(ctx context.Context, serviceName, shard string) ServiceResponse {
+	return GetWithOptions(ctx, serviceName, QueryOptions{Shard: shard})
+}
+
+// GetWithOptions is similar to Get, but all host-based operations use the provided query options. A
+// balancer set in the options keeps its state across the queries:
+//
+//	balancer := NewRoundRobinBalancer()
+//	url, err := GetWithOptions(ctx, "my-service", QueryOptions{Balancer: balancer}).URL(ctx, "http", "/")
+//
+// The consistency of the options also applies to the read of the service infos.
+func GetWithOptions(ctx context.Context, serviceName string, opts QueryOptions) ServiceResponse {
+	res, _ := GetWithReport(ctx, serviceName, opts)
+	return res
+}
+
+// GetWithReport is similar to GetWithOptions, but also returns the etcd index the service infos have been
+// read at.
+func GetWithReport(ctx context.Context, serviceName string, opts QueryOptions) (ServiceResponse, QueryReport) {
+	if cache := cacheFor(serviceName); cache != nil && opts.Consistency != ConsistencyQuorum {
+		s, index, found, ok, err := cache.serviceInfos()
+		if ok {
+			if !found {
+				s = &Service{Name: serviceName}
 			}
+			return &GetServiceResponse{
+				err:     err,
+				service: s,
+				opts:    opts,
+			}, QueryReport{Index: index}
 		}
+	}
+
+	res, err := KAPI().Get(ctx, "/services_infos/"+serviceName, &etcdv2.GetOptions{
+		Quorum: opts.Consistency == ConsistencyQuorum,
+	})
+
+	var etcdErr etcdv2.Error
+	if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+		return &GetServiceResponse{
+			err: nil,
+			service: &Service{
+				Name: serviceName,
+			},
+			opts: opts,
+		}, QueryReport{Index: etcdErr.Index}
+	}
+	if err != nil {
 		return &GetServiceResponse{
 			err:     err,
 			service: nil,
-		}
+		}, QueryReport{}
 	}
 
 	s, err := buildServiceFromNode(ctx, res.Node)
@@ -67,24 +118,13 @@ func Get(ctx context.Context, service string) ServiceResponse {
 		return &GetServiceResponse{
 			err:     err,
 			service: nil,
-		}
+		}, QueryReport{Index: res.Index}
 	}
 	return &GetServiceResponse{
 		err:     nil,
 		service: s,
-		shard:   "",
-	}
-}
-
-// GetForShard is similar to Get, but all host-based operations are filtered on the provided shard.
-func GetForShard(ctx context.Context, serviceName, shard string) ServiceResponse {
-	res := Get(ctx, serviceName)
-	getRes, ok := res.(*GetServiceResponse)
-	if !ok {
-		return res
-	}
-	getRes.shard = shard
-	return getRes
+		opts:    opts,
+	}, QueryReport{Index: res.Index}
 }
 
 // GetServiceResponse is the implementation of the ServiceResponse interface used by the Get method.
@@ -93,7 +133,7 @@ func GetForShard(ctx context.Context, serviceName, shard string) ServiceResponse
 type GetServiceResponse struct {
 	service *Service
 	err     error
-	shard   string
+	opts    QueryOptions
 }
 
 // Err will return an error if an error happened when you've called the Get method,
@@ -119,8 +159,7 @@ func (q *GetServiceResponse) All(ctx context.Context) (Hosts, error) {
 		return nil, q.err
 	}
 
-	opts := QueryOptions{Shard: q.shard}
-	hosts, err := q.service.All(ctx, opts)
+	hosts, err := q.service.All(ctx, q.opts)
 	if err != nil {
 		return nil, err
 	}
@@ -128,7 +167,22 @@ func (q *GetServiceResponse) All(ctx context.Context) (Hosts, error) {
 	return hosts, nil
 }
 
-// One will return a host chosen randomly in all the hosts of the service.
+// Shards will return all the hosts registered to the service grouped by shard. The hosts which are not on
+// a shard are grouped under the Unsharded key. The shard of the ServiceResponse is ignored.
+func (q *GetServiceResponse) Shards(ctx context.Context) (map[string]Hosts, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	shards, err := q.service.Shards(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return shards, nil
+}
+
+// One will return a host chosen by the balancer of the query options in all the hosts of the service.
 //
 // If the ServiceResponse is errored, the errors will be passed to the HostResponse.
 func (q *GetServiceResponse) One(ctx context.Context) HostResponse {
@@ -139,8 +193,7 @@ func (q *GetServiceResponse) One(ctx context.Context) HostResponse {
 		}
 	}
 
-	opts := QueryOptions{Shard: q.shard}
-	host, err := q.service.One(ctx, opts)
+	host, err := q.service.One(ctx, q.opts)
 	if err != nil {
 		return &GetHostResponse{
 			err:  err,
@@ -164,8 +217,7 @@ func (q *GetServiceResponse) First(ctx context.Context) HostResponse {
 		}
 	}
 
-	opts := QueryOptions{Shard: q.shard}
-	host, err := q.service.First(ctx, opts)
+	host, err := q.service.First(ctx, q.opts)
 	if err != nil {
 		return &GetHostResponse{
 			err:  err,
@@ -178,15 +230,38 @@ func (q *GetServiceResponse) First(ctx context.Context) HostResponse {
 	}
 }
 
+// ForKey will return the host responsible for a routing key, with rendezvous hashing over the host UUIDs.
+//
+// If the ServiceResponse is errored, the errors will be passed to the HostResponse.
+func (q *GetServiceResponse) ForKey(ctx context.Context, key string) HostResponse {
+	if q.err != nil {
+		return &GetHostResponse{
+			err:  q.err,
+			host: nil,
+		}
+	}
+
+	host, err := q.service.ForKey(ctx, key, q.opts)
+	if err != nil {
+		return &GetHostResponse{
+			err:  err,
+			host: nil,
+		}
+	}
+	return &GetHostResponse{
+		err:  nil,
+		host: host,
+	}
+}
+
 // URL build url for the specified service. If the service is not public,
-// a random host will be chosen and a url will be generated.
+// a host will be chosen by One and a url will be generated.
 func (q *GetServiceResponse) URL(ctx context.Context, scheme, path string) (string, error) {
 	if q.err != nil {
 		return "", q.err
 	}
 
-	opts := QueryOptions{Shard: q.shard}
-	url, err := q.service.URL(ctx, scheme, path, opts)
+	url, err := q.service.URL(ctx, scheme, path, q.opts)
 	if err != nil {
 		return "", err
 	}
@@ -34,8 +34,8 @@ func TestGet(t *testing.T) {
 		host2 := genHost("host2")
 		host1.Name = "test_service_get"
 		host2.Name = "test_service_get"
-		w1 := Register(ctx1, "test_service_get", host1)
-		w2 := Register(ctx2, "test_service_get", host2)
+		w1 := registerForTest(t, ctx1, "test_service_get", host1, RegisterOptions{})
+		w2 := registerForTest(t, ctx2, "test_service_get", host2, RegisterOptions{})
 		require.NoError(t, w1.WaitRegistration(t.Context()))
 		require.NoError(t, w2.WaitRegistration(t.Context()))
 
@@ -45,12 +45,16 @@ func TestGet(t *testing.T) {
 
 		if hosts[0].UUID == w1.UUID() {
 			host1.UUID = hosts[0].UUID
+			host1.RegisteredAt = hosts[0].RegisteredAt
 			host2.UUID = hosts[1].UUID
+			host2.RegisteredAt = hosts[1].RegisteredAt
 			assert.Equal(t, host1, *hosts[0])
 			assert.Equal(t, host2, *hosts[1])
 		} else {
 			host1.UUID = hosts[1].UUID
+			host1.RegisteredAt = hosts[1].RegisteredAt
 			host2.UUID = hosts[0].UUID
+			host2.RegisteredAt = hosts[0].RegisteredAt
 			assert.Equal(t, host1, *hosts[1])
 			assert.Equal(t, host2, *hosts[0])
 		}
@@ -159,8 +163,8 @@ func TestGetForShard(t *testing.T) {
 		hostShard1.Shard = testShard1ID
 		hostShard1.Hostname = "host-shard-1.dev"
 
-		w1 := Register(ctx1, "test_service_get_for_shard", hostShard0)
-		w2 := Register(ctx2, "test_service_get_for_shard", hostShard1)
+		w1 := registerForTest(t, ctx1, "test_service_get_for_shard", hostShard0, RegisterOptions{})
+		w2 := registerForTest(t, ctx2, "test_service_get_for_shard", hostShard1, RegisterOptions{})
 		require.NoError(t, w1.WaitRegistration(t.Context()))
 		require.NoError(t, w2.WaitRegistration(t.Context()))
 
@@ -182,7 +186,7 @@ func TestGetForShard(t *testing.T) {
 	t.Run("When no host matches the shard, it should return shard-specific no-host errors", func(t *testing.T) {
 		host := genHost("host-no-match")
 		host.Shard = testShardID
-		w := Register(t.Context(), "test_service_get_for_shard_no_match", host)
+		w := registerForTest(t, t.Context(), "test_service_get_for_shard_no_match", host, RegisterOptions{})
 		require.NoError(t, w.WaitRegistration(t.Context()))
 
 		hosts, err := GetForShard(t.Context(), "test_service_get_for_shard_no_match", testShard1ID).All(t.Context())
@@ -202,3 +206,38 @@ func TestGetForShard(t *testing.T) {
 		assert.Empty(t, url)
 	})
 }
+
+func TestGetWithOptionsConsistency(t *testing.T) {
+	w := registerForTest(t, t.Context(), "test_get_consistency", genHost("test-get-consistency"), RegisterOptions{})
+	require.NoError(t, w.WaitRegistration(t.Context()))
+
+	t.Run("It should report the etcd index the hosts have been read at", func(t *testing.T) {
+		for _, consistency := range []Consistency{ConsistencyLocal, ConsistencyQuorum} {
+			opts := QueryOptions{Consistency: consistency}
+			res, infosReport := GetWithReport(t.Context(), "test_get_consistency", opts)
+			require.NoError(t, res.Err())
+			assert.NotZero(t, infosReport.Index)
+
+			s, err := res.Service(t.Context())
+			require.NoError(t, err)
+			hosts, report, err := s.AllWithReport(t.Context(), opts)
+			require.NoError(t, err)
+			assert.Len(t, hosts, 1)
+			assert.GreaterOrEqual(t, report.Index, infosReport.Index)
+		}
+	})
+
+	t.Run("Quorum reads should not be served by the cache", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		defer cancel()
+		require.NoError(t, EnableCache(ctx, "test_get_consistency_cached", CacheOptions{}))
+
+		w := registerForTest(t, t.Context(), "test_get_consistency_cached", genHost("test-get-consistency-cached"), RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		// The registration may not have reached the cache yet, but a quorum read always sees it
+		hosts, err := GetWithOptions(t.Context(), "test_get_consistency_cached", QueryOptions{Consistency: ConsistencyQuorum}).All(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, []string{w.UUID()}, hostUUIDs(hosts))
+	})
+}
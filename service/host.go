@@ -4,7 +4,10 @@ import (
 	"context"
 	stderrors "errors"
 	"fmt"
+	"net"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/Scalingo/go-utils/errors/v3"
 )
@@ -43,9 +46,11 @@ type Host struct {
 	Name string `json:"service_name"`
 	// Ports is the ports accessible on a public network if the service is Public or the ports accessible on a private network if the service is private
 	Ports Ports `json:"ports"`
-	// User name used to authenticate to this service. The Register function can override this at any time if the service is public
+	// User name used to authenticate to this service. The Register function can override this at any time if the credentials are shared.
+	// Otherwise, this is the user name of this host, which can be rotated with RotateHostCredentials
 	User string `json:"user,omitempty"`
-	// Password used to authenticate to this service. The Register function can override this at any time if the service is public
+	// Password used to authenticate to this service. The Register function can override this at any time if the credentials are shared.
+	// Otherwise, this is the password of this host, which can be rotated with RotateHostCredentials
 	Password string `json:"password,omitempty"`
 	// Public is set to true if the service is public
 	Public bool `json:"public,omitempty"`
@@ -61,14 +66,60 @@ type Host struct {
 	//
 	// This field is an empty string if the service is not sharded.
 	Shard string `json:"shard,omitempty"`
+	// Zone is the availability zone of the host. The Register function defaults it to the ETCD_DISCOVERY_ZONE
+	// environment variable.
+	Zone string `json:"zone,omitempty"`
+	// Region is the region of the host. The Register function defaults it to the ETCD_DISCOVERY_REGION
+	// environment variable.
+	Region string `json:"region,omitempty"`
 	// UUID is the service UUID, this must have the following pattern: uuid-PrivateHostname
 	UUID string `json:"uuid,omitempty"`
+	// CredentialsScope tells where the credentials of the service are stored.
+	// This defaults to CredentialsScopeService if the service is public and to CredentialsScopeHost otherwise.
+	// Set it to CredentialsScopeService to share the credentials between all the hosts of a private service.
+	// A public service always shares its credentials, Register and RegisterExternal reject CredentialsScopeHost.
+	CredentialsScope CredentialsScope `json:"credentials_scope,omitempty"`
+	// GenerateCredentials is only used by the Register function. If true and the credentials are shared, the Register function
+	// keeps the credentials already stored in /services_infos/<name>, or generates them if the service does not have any.
+	// Otherwise, the Register function generates the host credentials if User and Password are empty.
+	GenerateCredentials bool `json:"-"`
+	// External is set to true for the permanent host entries created with RegisterExternal, which are not
+	// maintained by a Register heartbeat. This will be overwritten by the Register function.
+	External bool `json:"external,omitempty"`
+	// RegisteredAt is the time at which the host has been registered. This will be overwritten by the
+	// Register and RegisterExternal functions, and is zero for the hosts registered by older versions.
+	RegisteredAt time.Time `json:"registered_at,omitzero"`
+}
+
+// shard returns the shard of the host, or Unsharded if the host is not on a shard.
+func (h *Host) shard() string {
+	if h.Shard == "" {
+		return Unsharded
+	}
+	return h.Shard
+}
+
+// validate returns an error if the host cannot be registered.
+func (h *Host) validate() error {
+	if h.Shard == Unsharded {
+		return fmt.Errorf("%w: %s is reserved to the hosts which are not on a shard", ErrInvalidShard, Unsharded)
+	}
+	return h.CredentialsScope.validate(h.Public)
 }
 
 // URL will return a valid url to contact this service on the specific protocol provided by the scheme parameter
 func (h *Host) URL(ctx context.Context, scheme, path string) (string, error) {
+	u, err := h.URLStruct(ctx, scheme, path)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// URLStruct is similar to URL, but returns a *url.URL. The credentials and the path are escaped, and an IPv6
+// hostname is bracketed. path can contain a query and a fragment, it is not unescaped.
+func (h *Host) URLStruct(ctx context.Context, scheme, path string) (*url.URL, error) {
 	var (
-		url  string
 		port string
 		ok   bool
 		err  error
@@ -76,36 +127,39 @@ func (h *Host) URL(ctx context.Context, scheme, path string) (string, error) {
 
 	if scheme != "" {
 		if port, ok = h.Ports[scheme]; !ok {
-			return "", ErrUnknownScheme
+			return nil, ErrUnknownScheme
 		}
 	} else {
 		scheme, port, err = h.findSchemeAndPort(h.Ports)
 		if err != nil {
-			return "", errors.Wrap(ctx, err, "find scheme and port")
+			return nil, errors.Wrap(ctx, err, "find scheme and port")
 		}
 	}
 
-	if h.User != "" {
-		url = fmt.Sprintf("%s://%s:%s@%s:%s%s", scheme, h.User, h.Password, h.Hostname, port, path)
-	} else {
-		url = fmt.Sprintf("%s://%s:%s%s", scheme, h.Hostname, port, path)
-	}
-	return url, nil
+	return buildURL(scheme, h.User, h.Password, h.Hostname, port, path), nil
 }
 
 // PrivateURL will provide a valid url to contact this service on the Private network
 // this method will fall back to the URL method if the host does not provide any PrivateURL
 func (h *Host) PrivateURL(ctx context.Context, scheme, path string) (string, error) {
+	u, err := h.PrivateURLStruct(ctx, scheme, path)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// PrivateURLStruct is similar to PrivateURL, but returns a *url.URL like URLStruct.
+func (h *Host) PrivateURLStruct(ctx context.Context, scheme, path string) (*url.URL, error) {
 	if h.PrivateHostname == "" {
-		url, err := h.URL(ctx, scheme, path)
+		u, err := h.URLStruct(ctx, scheme, path)
 		if err != nil {
-			return "", errors.Wrap(ctx, err, "get public url")
+			return nil, errors.Wrap(ctx, err, "get public url")
 		}
-		return url, nil
+		return u, nil
 	}
 
 	var (
-		url  string
 		port string
 		ok   bool
 		err  error
@@ -113,22 +167,71 @@ func (h *Host) PrivateURL(ctx context.Context, scheme, path string) (string, err
 
 	if scheme != "" {
 		if port, ok = h.PrivatePorts[scheme]; !ok {
-			return "", ErrUnknownScheme
+			return nil, ErrUnknownScheme
 		}
 	} else {
 		scheme, port, err = h.findSchemeAndPort(h.PrivatePorts)
 		if err != nil {
-			return "", errors.Wrap(ctx, err, "find scheme and port")
+			return nil, errors.Wrap(ctx, err, "find scheme and port")
 		}
 	}
 
-	if h.User != "" {
-		url = fmt.Sprintf("%s://%s:%s@%s:%s%s", scheme, h.User, h.Password, h.PrivateHostname, port, path)
-	} else {
-		url = fmt.Sprintf("%s://%s:%s%s", scheme, h.PrivateHostname, port, path)
+	return buildURL(scheme, h.User, h.Password, h.PrivateHostname, port, path), nil
+}
+
+// buildURL returns the url of a host. The credentials are only set if user is not empty, and the brackets
+// of an IPv6 hostname are optional. path is not parsed as a URL: it is cut at its first "#" and at the first
+// "?" before it, to keep its query and its fragment. The valid escapes of the path, the query and the
+// fragment are kept, and the other characters which are not allowed there are escaped.
+func buildURL(scheme, user, password, hostname, port, path string) *url.URL {
+	path, fragment, _ := strings.Cut(path, "#")
+	path, query, _ := strings.Cut(path, "?")
+	hostname = strings.TrimSuffix(strings.TrimPrefix(hostname, "["), "]")
+
+	u := &url.URL{
+		Scheme:   scheme,
+		Host:     net.JoinHostPort(hostname, port),
+		RawQuery: escapeQuery(query),
+	}
+	// url.URL only uses RawPath and RawFragment if they are valid escapings of Path and Fragment
+	u.Path, u.RawPath = unescapeURLPart(path)
+	u.Fragment, u.RawFragment = unescapeURLPart(fragment)
+	if user != "" {
+		u.User = url.UserPassword(user, password)
 	}
+	return u
+}
 
-	return url, nil
+// unescapeURLPart returns the unescaped part of a URL and its escaped form. A part which has invalid escapes
+// is returned as is, with no escaped form.
+func unescapeURLPart(part string) (string, string) {
+	unescaped, err := url.PathUnescape(part)
+	if err != nil {
+		return part, ""
+	}
+	return unescaped, part
+}
+
+// escapeQuery escapes the characters which are not allowed in a query, keeping its valid escapes.
+func escapeQuery(query string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '%' && i+2 < len(query) && isHex(query[i+1]) && isHex(query[i+2]):
+			escaped.WriteString(query[i : i+3])
+			i += 2
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-._~!$&'()*+,;=:@/?", c) >= 0:
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
 }
 
 func (h *Host) findSchemeAndPort(ports Ports) (string, string, error) {
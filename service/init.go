@@ -29,6 +29,21 @@ func KAPI() etcdv2.KeysAPI {
 	return etcdv2.NewKeysAPI(Client())
 }
 
+// isEtcdError returns true if err is an etcd error with the given error code.
+func isEtcdError(err error, code int) bool {
+	var etcdErr etcdv2.Error
+	if stderrors.As(err, &etcdErr) {
+		return etcdErr.Code == code
+	}
+
+	var etcdErrPtr *etcdv2.Error
+	if stderrors.As(err, &etcdErrPtr) {
+		return etcdErrPtr.Code == code
+	}
+
+	return false
+}
+
 // Client will generate a valid etcd client from the following environment variables:
 //   - ETCD_HOSTS: a list of etcd hosts comma separated
 //   - ETCD_HOST: a single etcd host
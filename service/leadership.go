@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	etcdv2 "go.etcd.io/etcd/client/v2"
+
+	"github.com/Scalingo/go-utils/errors/v3"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// LeadershipChange is sent by Leadership.Changes every time the leader of a service changes.
+type LeadershipChange struct {
+	// IsLeader is true if the campaigning host is the leader
+	IsLeader bool
+	// Leader is the current leader, or nil if the service has no leader
+	Leader *Host
+	// Term is the term of the current leader, see Leadership.Term
+	Term uint64
+}
+
+// Leadership is the handle returned by Campaign. It tells whether the campaigning host is the
+// leader of its service, and which host is the leader otherwise.
+type Leadership struct {
+	uuid           string
+	changes        chan LeadershipChange
+	doneChan       chan struct{}
+	mutex          sync.Mutex
+	isLeader       bool
+	leader         *Host
+	term           uint64
+	doneErr        error
+	signalDoneOnce sync.Once
+}
+
+// Campaign makes the host a candidate to the leadership of a service. Among all the hosts
+// campaigning for the same service, a single one is the leader at any time.
+//
+// The leadership is stored under /services_leaders/<name> and maintained with the same TTL
+// heartbeat as Register: if the leader dies, another candidate takes over once the key has expired.
+// The leadership is resigned when the context is canceled.
+func Campaign(ctx context.Context, service string, host Host) *Leadership {
+	host = prepareHost(service, host)
+
+	ctx, _ = logger.WithFieldsToCtx(ctx, logrus.Fields{
+		"hostname":     host.Hostname,
+		"service_name": host.Name,
+	})
+
+	hostJSON, _ := json.Marshal(&host)
+
+	leadership := &Leadership{
+		uuid:     host.UUID,
+		changes:  make(chan LeadershipChange, 1),
+		doneChan: make(chan struct{}),
+	}
+
+	go func() {
+		err := leadership.campaign(ctx, fmt.Sprintf("/services_leaders/%s", service), string(hostJSON))
+		leadership.signalDone(err)
+	}()
+
+	return leadership
+}
+
+// UUID returns the UUID of the campaigning host
+func (l *Leadership) UUID() string {
+	return l.uuid
+}
+
+// IsLeader is a non blocking method that returns true if the campaigning host is the leader of the service
+func (l *Leadership) IsLeader() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.isLeader
+}
+
+// Leader returns the current leader of the service, or nil if the service has no leader
+func (l *Leadership) Leader() *Host {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.leader
+}
+
+// Term returns the term of the current leader. The term increases every time a new leader
+// is elected, so it can be used as a fencing token by the leader.
+func (l *Leadership) Term() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.term
+}
+
+// Changes returns a channel which receives the latest leadership state every time it changes.
+// Only the latest change is kept if the channel is not read. The channel is closed once the
+// campaign has stopped.
+func (l *Leadership) Changes() <-chan LeadershipChange {
+	return l.changes
+}
+
+// Done returns a channel which is closed once the campaign has stopped
+func (l *Leadership) Done() <-chan struct{} {
+	return l.doneChan
+}
+
+// Err returns nil while the campaign is running, and the reason why it stopped otherwise
+func (l *Leadership) Err() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.doneErr
+}
+
+func (l *Leadership) campaign(ctx context.Context, leaderKey, hostJSON string) error {
+	log := logger.Get(ctx)
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		electedAt := time.Now()
+		res, err := KAPI().Set(ctx, leaderKey, hostJSON, &etcdv2.SetOptions{
+			TTL:       heartbeatTTL,
+			PrevExist: etcdv2.PrevNoExist,
+		})
+		if err == nil {
+			log.Info("Elected leader")
+			l.setLeader(ctx, true, res.Node)
+			err = keepAlive(ctx, leaderKey, hostJSON, heartbeatTTL, electedAt)
+			l.setLeader(ctx, false, nil)
+			if ctx.Err() != nil {
+				l.resign(ctx, leaderKey, hostJSON)
+				return ctx.Err()
+			}
+			log.WithError(err).Error("Lost leadership")
+			continue
+		}
+
+		if !isEtcdError(err, etcdv2.ErrorCodeNodeExist) {
+			log.WithError(err).Errorf("Fail to campaign for leadership (%v)", Client().Endpoints())
+			err = sleepOrDone(ctx, time.Second)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = l.follow(ctx, leaderKey)
+		if err != nil && ctx.Err() == nil {
+			log.WithError(err).Errorf("Lost watcher of '%s' (%v)", leaderKey, Client().Endpoints())
+			err = sleepOrDone(ctx, time.Second)
+		}
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// follow updates the current leader and waits until the leader key is removed.
+func (l *Leadership) follow(ctx context.Context, leaderKey string) error {
+	res, err := KAPI().Get(ctx, leaderKey, nil)
+	if etcdv2.IsKeyNotFound(err) {
+		// The leader key has been removed in the meantime, campaign again.
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(ctx, err, "get leader key")
+	}
+	l.setLeader(ctx, false, res.Node)
+
+	watcher := KAPI().Watcher(leaderKey, &etcdv2.WatcherOptions{AfterIndex: res.Index})
+	for {
+		res, err := watcher.Next(ctx)
+		if err != nil {
+			return errors.Wrap(ctx, err, "watch leader key")
+		}
+
+		switch res.Action {
+		case "delete", "expire", "compareAndDelete":
+			l.setLeader(ctx, false, nil)
+			return nil
+		case "set", "create", "update", "compareAndSwap":
+			l.setLeader(ctx, false, res.Node)
+		}
+	}
+}
+
+// resign removes the leader key, if we still own it, so that another candidate does not
+// have to wait for the key expiration.
+func (l *Leadership) resign(ctx context.Context, leaderKey, hostJSON string) {
+	ctx, cancel := withCleanupTimeout(ctx)
+	defer cancel()
+
+	_, err := KAPI().Delete(ctx, leaderKey, &etcdv2.DeleteOptions{PrevValue: hostJSON})
+	if err != nil && !etcdv2.IsKeyNotFound(err) && !isEtcdError(err, etcdv2.ErrorCodeTestFailed) {
+		logger.Get(ctx).WithError(err).Errorf("remove leader key %s", leaderKey)
+	}
+}
+
+// setLeader updates the leadership state from the leader key node, and notifies the change if any.
+// node is nil if the service has no leader.
+func (l *Leadership) setLeader(ctx context.Context, isLeader bool, node *etcdv2.Node) {
+	var (
+		leader *Host
+		term   uint64
+	)
+	if node != nil {
+		host, err := buildHostFromNode(ctx, node)
+		if err != nil {
+			logger.Get(ctx).WithError(err).Errorf("Invalid leader key %s", node.Key)
+		}
+		leader = host
+		term = node.CreatedIndex
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.isLeader == isLeader && l.term == term && (l.leader == nil) == (leader == nil) {
+		return
+	}
+	l.isLeader = isLeader
+	l.leader = leader
+	if term != 0 {
+		l.term = term
+	}
+
+	change := LeadershipChange{IsLeader: isLeader, Leader: leader, Term: l.term}
+	// Only keep the latest change if the previous one has not been read yet.
+	select {
+	case <-l.changes:
+	default:
+	}
+	l.changes <- change
+}
+
+func (l *Leadership) signalDone(err error) {
+	l.signalDoneOnce.Do(func() {
+		l.mutex.Lock()
+		l.isLeader = false
+		l.doneErr = err
+		close(l.changes)
+		l.mutex.Unlock()
+		close(l.doneChan)
+	})
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCampaign(t *testing.T) {
+	t.Run("With two candidates for the same service", func(t *testing.T) {
+		ctx1, cancel1 := context.WithCancel(t.Context())
+		defer cancel1()
+		ctx2, cancel2 := context.WithCancel(t.Context())
+		defer cancel2()
+
+		l1 := Campaign(ctx1, "test_campaign", genHost("test-campaign-1"))
+		require.Eventually(t, l1.IsLeader, 3*time.Second, 10*time.Millisecond)
+		l2 := Campaign(ctx2, "test_campaign", genHost("test-campaign-2"))
+		t.Cleanup(func() {
+			cancel2()
+			<-l2.Done()
+		})
+
+		t.Run("A single candidate should be the leader", func(t *testing.T) {
+			require.Eventually(t, func() bool {
+				return l2.Leader() != nil
+			}, 3*time.Second, 10*time.Millisecond)
+
+			assert.True(t, l1.IsLeader())
+			assert.False(t, l2.IsLeader())
+			assert.Equal(t, l1.UUID(), l2.Leader().UUID)
+			assert.Equal(t, l1.UUID(), l1.Leader().UUID)
+			assert.Equal(t, l1.Term(), l2.Term())
+			assert.NotZero(t, l1.Term())
+		})
+
+		t.Run("When the leader resigns, the other candidate should be elected with a greater term", func(t *testing.T) {
+			previousTerm := l1.Term()
+			cancel1()
+
+			select {
+			case <-l1.Done():
+			case <-time.After(3 * time.Second):
+				t.Fatal("timed out waiting for the campaign to stop")
+			}
+			require.ErrorIs(t, l1.Err(), context.Canceled)
+			assert.False(t, l1.IsLeader())
+
+			require.Eventually(t, l2.IsLeader, 3*time.Second, 10*time.Millisecond)
+			assert.Greater(t, l2.Term(), previousTerm)
+			assert.Equal(t, l2.UUID(), l2.Leader().UUID)
+
+			var change LeadershipChange
+			require.Eventually(t, func() bool {
+				select {
+				case change = <-l2.Changes():
+				default:
+				}
+				return change.IsLeader
+			}, time.Second, 10*time.Millisecond)
+			assert.Equal(t, l2.Term(), change.Term)
+		})
+	})
+
+	t.Run("When the leader key cannot be refreshed, the leader should step down once its TTL has elapsed", func(t *testing.T) {
+		var requests atomic.Int32
+		useFakeEtcdServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPut || requests.Add(1) > 1 {
+				writeEtcdError(t, w)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, err := w.Write([]byte(`{"action":"create","node":{"key":"/services_leaders/test_campaign_refresh","value":"{}","createdIndex":1,"modifiedIndex":1}}`))
+			assert.NoError(t, err)
+		})
+
+		l := Campaign(t.Context(), "test_campaign_refresh", genHost("test-campaign-refresh"))
+		t.Cleanup(func() {
+			<-l.Done()
+		})
+		require.Eventually(t, l.IsLeader, time.Second, 10*time.Millisecond)
+		electedAt := time.Now()
+
+		require.Eventually(t, func() bool {
+			return !l.IsLeader()
+		}, heartbeatTTL+time.Second, 10*time.Millisecond)
+		assert.Less(t, time.Since(electedAt), heartbeatTTL+500*time.Millisecond)
+	})
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"maps"
+	"net"
+)
+
+// defaultPortName is the name of the port registered for a listener when none is given.
+const defaultPortName = "http"
+
+// ListenerOptions configures the registration of a listener.
+type ListenerOptions struct {
+	// Host describes the registered host. The port of the listener is added to its PrivatePorts, and to its
+	// Ports if they don't already define this port. If PrivateHostname is empty and the listener is bound to
+	// a specific IP, this IP is used as PrivateHostname.
+	Host Host
+	// PortName is the name of the port of the listener. This defaults to "http".
+	PortName string
+	// RegisterOptions configures the registration.
+	RegisterOptions RegisterOptions
+}
+
+// RegisterListener registers a host serving on ln. The port of the host is derived from the address of
+// ln, so that the registered host always matches the listener.
+func RegisterListener(ctx context.Context, service string, ln net.Listener, opts ListenerOptions) *Registration {
+	return RegisterWithOptions(ctx, service, listenerHost(ln, opts), opts.RegisterOptions)
+}
+
+// listenerHost returns the host described in opts, completed with the address of ln.
+func listenerHost(ln net.Listener, opts ListenerOptions) Host {
+	host := opts.Host
+	portName := opts.PortName
+	if portName == "" {
+		portName = defaultPortName
+	}
+
+	ip, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		// Not a network listener (e.g. a unix socket), there is no port to register
+		return host
+	}
+
+	// The ports of the caller must not be modified
+	host.PrivatePorts = maps.Clone(host.PrivatePorts)
+	if host.PrivatePorts == nil {
+		host.PrivatePorts = Ports{}
+	}
+	host.PrivatePorts[portName] = port
+
+	if _, ok := host.Ports[portName]; !ok {
+		host.Ports = maps.Clone(host.Ports)
+		if host.Ports == nil {
+			host.Ports = Ports{}
+		}
+		host.Ports[portName] = port
+	}
+
+	if host.PrivateHostname == "" {
+		addr := net.ParseIP(ip)
+		if addr != nil && !addr.IsUnspecified() {
+			host.PrivateHostname = addr.String()
+		}
+	}
+
+	return host
+}
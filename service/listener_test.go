@@ -0,0 +1,50 @@
+package service
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	t.Run("It should register the port of the listener", func(t *testing.T) {
+		w := RegisterListener(t.Context(), "test_register_listener", ln, ListenerOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		host, err := Get(t.Context(), "test_register_listener").First(t.Context()).Host(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.1", host.PrivateHostname)
+		assert.Equal(t, Ports{"http": port}, host.PrivatePorts)
+	})
+
+	t.Run("It should keep the public ports and hostname of the host", func(t *testing.T) {
+		ports := Ports{"https": "443"}
+		host := listenerHost(ln, ListenerOptions{
+			Host:     Host{Hostname: "public.dev", Public: true, Ports: ports, PrivateHostname: "private.dev"},
+			PortName: "https",
+		})
+		assert.Equal(t, "private.dev", host.PrivateHostname)
+		assert.Equal(t, Ports{"https": "443"}, host.Ports)
+		assert.Equal(t, Ports{"https": port}, host.PrivatePorts)
+		// The ports of the caller must not be modified
+		assert.Equal(t, Ports{"https": "443"}, ports)
+	})
+
+	t.Run("With a listener on all the interfaces, it should not set the private hostname", func(t *testing.T) {
+		ln, err := net.Listen("tcp", ":0")
+		require.NoError(t, err)
+		defer ln.Close()
+
+		host := listenerHost(ln, ListenerOptions{})
+		assert.Empty(t, host.PrivateHostname)
+		assert.NotEmpty(t, host.Ports["http"])
+	})
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"os"
+)
+
+// LocalityPolicy tells which hosts are preferred by a query, depending on the locality of the caller.
+type LocalityPolicy string
+
+const (
+	// LocalityAny does not prefer any host. This is the default policy.
+	LocalityAny LocalityPolicy = ""
+	// LocalityPreferZone prefers the hosts of the zone of the caller, then the hosts of its region, then any
+	// host.
+	LocalityPreferZone LocalityPolicy = "zone"
+	// LocalityPreferRegion prefers the hosts of the region of the caller, then any host.
+	LocalityPreferRegion LocalityPolicy = "region"
+)
+
+// hostLocalityFromEnv returns the host with its zone and region from the environment, if they are not set.
+func hostLocalityFromEnv(host Host) Host {
+	if host.Zone == "" {
+		host.Zone = os.Getenv("ETCD_DISCOVERY_ZONE")
+	}
+	if host.Region == "" {
+		host.Region = os.Getenv("ETCD_DISCOVERY_REGION")
+	}
+	return host
+}
+
+// preferLocal returns the hosts preferred by the locality policy of the query. A group of local hosts is
+// only preferred if it has at least MinLocalHosts hosts, otherwise the query falls back to the next group.
+func (o QueryOptions) preferLocal(hosts Hosts) Hosts {
+	if o.Locality == LocalityAny {
+		return hosts
+	}
+
+	zone, region := o.Zone, o.Region
+	if zone == "" {
+		zone = os.Getenv("ETCD_DISCOVERY_ZONE")
+	}
+	if region == "" {
+		region = os.Getenv("ETCD_DISCOVERY_REGION")
+	}
+	minLocalHosts := max(o.MinLocalHosts, 1)
+
+	if o.Locality == LocalityPreferZone && zone != "" {
+		local := hosts.filter(func(h *Host) bool { return h.Zone == zone && (region == "" || h.Region == region) })
+		if len(local) >= minLocalHosts {
+			return local
+		}
+	}
+	if region != "" {
+		local := hosts.filter(func(h *Host) bool { return h.Region == region })
+		if len(local) >= minLocalHosts {
+			return local
+		}
+	}
+	return hosts
+}
+
+// filter returns the hosts matching keep.
+func (hs Hosts) filter(keep func(h *Host) bool) Hosts {
+	filtered := make(Hosts, 0, len(hs))
+	for _, h := range hs {
+		if keep(h) {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered
+}
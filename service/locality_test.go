@@ -0,0 +1,77 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryOptionsPreferLocal(t *testing.T) {
+	hosts := Hosts{
+		{UUID: "eu-a-1", Region: "eu", Zone: "eu-a"},
+		{UUID: "eu-a-2", Region: "eu", Zone: "eu-a"},
+		{UUID: "eu-b-1", Region: "eu", Zone: "eu-b"},
+		{UUID: "us-a-1", Region: "us", Zone: "us-a"},
+	}
+
+	t.Run("Without locality policy, it should keep all the hosts", func(t *testing.T) {
+		assert.Len(t, QueryOptions{Zone: "eu-a", Region: "eu"}.preferLocal(hosts), 4)
+	})
+
+	t.Run("With the zone policy, it should prefer the zone, then the region, then any host", func(t *testing.T) {
+		opts := QueryOptions{Locality: LocalityPreferZone, Zone: "eu-a", Region: "eu"}
+		assert.Equal(t, []string{"eu-a-1", "eu-a-2"}, hostUUIDs(opts.preferLocal(hosts)))
+
+		opts.Zone = "eu-c"
+		assert.Equal(t, []string{"eu-a-1", "eu-a-2", "eu-b-1"}, hostUUIDs(opts.preferLocal(hosts)))
+
+		opts.Region = "ap"
+		assert.Len(t, opts.preferLocal(hosts), 4)
+	})
+
+	t.Run("With the region policy, it should prefer the region", func(t *testing.T) {
+		opts := QueryOptions{Locality: LocalityPreferRegion, Zone: "eu-a", Region: "eu"}
+		assert.Equal(t, []string{"eu-a-1", "eu-a-2", "eu-b-1"}, hostUUIDs(opts.preferLocal(hosts)))
+	})
+
+	t.Run("With too few local hosts, it should fall back to the next locality", func(t *testing.T) {
+		opts := QueryOptions{Locality: LocalityPreferZone, Zone: "eu-a", Region: "eu", MinLocalHosts: 3}
+		assert.Equal(t, []string{"eu-a-1", "eu-a-2", "eu-b-1"}, hostUUIDs(opts.preferLocal(hosts)))
+
+		opts.MinLocalHosts = 4
+		assert.Len(t, opts.preferLocal(hosts), 4)
+	})
+
+	t.Run("Without caller locality, it should use the environment", func(t *testing.T) {
+		t.Setenv("ETCD_DISCOVERY_ZONE", "us-a")
+		t.Setenv("ETCD_DISCOVERY_REGION", "us")
+		opts := QueryOptions{Locality: LocalityPreferZone}
+		assert.Equal(t, []string{"us-a-1"}, hostUUIDs(opts.preferLocal(hosts)))
+	})
+}
+
+func TestRegisterLocality(t *testing.T) {
+	t.Setenv("ETCD_DISCOVERY_ZONE", "eu-a")
+	t.Setenv("ETCD_DISCOVERY_REGION", "eu")
+
+	w1 := registerForTest(t, t.Context(), "test_register_locality", genHost("test-locality-1"), RegisterOptions{})
+	host2 := genHost("test-locality-2")
+	host2.Zone = "eu-b"
+	w2 := registerForTest(t, t.Context(), "test_register_locality", host2, RegisterOptions{})
+	require.NoError(t, w1.WaitRegistration(t.Context()))
+	require.NoError(t, w2.WaitRegistration(t.Context()))
+
+	s, err := Get(t.Context(), "test_register_locality").Service(t.Context())
+	require.NoError(t, err)
+	for range 10 {
+		host, err := s.One(t.Context(), QueryOptions{Locality: LocalityPreferZone, Zone: "eu-b"})
+		require.NoError(t, err)
+		assert.Equal(t, w2.UUID(), host.UUID)
+		assert.Equal(t, "eu", host.Region)
+	}
+
+	hosts, err := s.All(t.Context(), QueryOptions{Locality: LocalityPreferZone})
+	require.NoError(t, err)
+	assert.Equal(t, []string{w1.UUID()}, hostUUIDs(hosts))
+}
@@ -0,0 +1,382 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	etcdv2 "go.etcd.io/etcd/client/v2"
+
+	"github.com/Scalingo/go-utils/errors/v3"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+var (
+	ErrInvalidSemaphoreLimit  = stderrors.New("semaphore limit must be greater than 0")
+	ErrSemaphoreLimitMismatch = stderrors.New("the semaphore already exists with another limit")
+	ErrInvalidLockOptions     = stderrors.New("invalid lock options")
+	ErrLeaseLost              = stderrors.New("lease lost")
+)
+
+// lockLimitKey is the name of the key storing the limit of a semaphore, next to its candidates.
+// The candidates keys are created in order, so their names are numbers and never conflict with it.
+const lockLimitKey = "limit"
+
+// LockOptions are the options of LockWithOptions and SemaphoreWithOptions
+type LockOptions struct {
+	// TTL of the candidates keys, 5 seconds by default. It must be a whole number of seconds.
+	// A lease is lost if its key cannot be refreshed within the TTL, and the slot of a dead
+	// holder is released once the TTL has elapsed.
+	TTL time.Duration
+}
+
+func (o LockOptions) withDefaults() (LockOptions, error) {
+	if o.TTL == 0 {
+		o.TTL = heartbeatTTL
+	}
+	if o.TTL < time.Second || o.TTL%time.Second != 0 {
+		return o, fmt.Errorf("%w: the TTL must be a whole number of seconds, got %s", ErrInvalidLockOptions, o.TTL)
+	}
+	return o, nil
+}
+
+// lockHolder is stored in the etcd key of each holder, or candidate holder, of a lock or a semaphore.
+type lockHolder struct {
+	UUID     string `json:"uuid"`
+	Hostname string `json:"hostname"`
+}
+
+// Lease is a slot of a lock or of a semaphore held by the current process.
+//
+// The lease is kept with a TTL heartbeat, see LockOptions: if the holder dies, the slot is
+// released once its key has expired.
+type Lease struct {
+	name           string
+	uuid           string
+	key            string
+	value          string
+	cancel         context.CancelFunc
+	doneChan       chan struct{}
+	mutex          sync.Mutex
+	doneErr        error
+	signalDoneOnce sync.Once
+}
+
+// Lock acquires the lock named name. It blocks until the lock is acquired or the context is canceled.
+//
+// The lock is held until Release is called or the context is canceled.
+func Lock(ctx context.Context, name string) (*Lease, error) {
+	return LockWithOptions(ctx, name, LockOptions{})
+}
+
+// LockWithOptions is Lock with custom options
+func LockWithOptions(ctx context.Context, name string, opts LockOptions) (*Lease, error) {
+	lease, err := SemaphoreWithOptions(ctx, name, 1, opts)
+	if err != nil {
+		return nil, errors.Wrap(ctx, err, "acquire lock")
+	}
+	return lease, nil
+}
+
+// Semaphore acquires one of the n slots of the semaphore named name. It blocks until a slot is
+// acquired or the context is canceled.
+//
+// The candidates are queued under /locks/<name>, the n first ones hold a slot. The slot is held
+// until Release is called or the context is canceled.
+//
+// The limit is stored in /locks/<name>/limit by the first candidate, and ErrSemaphoreLimitMismatch
+// is returned if n differs from it. This key is never removed: it must be deleted manually to
+// change the limit of an existing semaphore. A lock is a semaphore with a limit of 1.
+func Semaphore(ctx context.Context, name string, n int) (*Lease, error) {
+	return SemaphoreWithOptions(ctx, name, n, LockOptions{})
+}
+
+// SemaphoreWithOptions is Semaphore with custom options
+func SemaphoreWithOptions(ctx context.Context, name string, n int, opts LockOptions) (*Lease, error) {
+	if n < 1 {
+		return nil, ErrInvalidSemaphoreLimit
+	}
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	holder := lockHolder{
+		UUID:     newHostUUID(hostname),
+		Hostname: hostname,
+	}
+	holderJSON, _ := json.Marshal(holder)
+
+	ctx, log := logger.WithFieldsToCtx(ctx, logrus.Fields{
+		"lock_name":   name,
+		"lock_holder": holder.UUID,
+	})
+
+	dir := fmt.Sprintf("/locks/%s", name)
+	err = storeSemaphoreLimit(ctx, dir, n)
+	if err != nil {
+		return nil, errors.Wrap(ctx, err, "store semaphore limit")
+	}
+
+	key, queuedAt, err := ensureLockCandidate(ctx, dir, string(holderJSON), opts.TTL)
+	if err != nil {
+		return nil, errors.Wrap(ctx, err, "queue lock candidate")
+	}
+
+	leaseCtx, cancel := context.WithCancel(ctx)
+	lease := &Lease{
+		name:     name,
+		uuid:     holder.UUID,
+		key:      key,
+		value:    string(holderJSON),
+		cancel:   cancel,
+		doneChan: make(chan struct{}),
+	}
+
+	// The candidate key must be kept alive while waiting for a slot, otherwise we would lose our
+	// position in the queue.
+	go func() {
+		err := keepAlive(leaseCtx, key, lease.value, opts.TTL, queuedAt)
+		lease.delete(ctx)
+		switch {
+		case ctx.Err() != nil:
+			err = ctx.Err()
+		case leaseCtx.Err() != nil:
+			// The lease has been released with Release
+			err = nil
+		default:
+			log.WithError(err).Error("Lost lease")
+			err = fmt.Errorf("%w: %w", ErrLeaseLost, err)
+		}
+		lease.signalDone(err)
+	}()
+
+	err = lease.wait(leaseCtx, dir, n)
+	if err != nil {
+		lease.cancel()
+		<-lease.doneChan
+		return nil, errors.Wrap(ctx, err, "wait for a free slot")
+	}
+	log.Info("Lease acquired")
+
+	return lease, nil
+}
+
+// LockHolders returns the UUIDs of the current holders of the lock or the semaphore named name.
+func LockHolders(ctx context.Context, name string) ([]string, error) {
+	res, err := KAPI().Get(ctx, fmt.Sprintf("/locks/%s", name), &etcdv2.GetOptions{Sort: true})
+	if err != nil {
+		if etcdv2.IsKeyNotFound(err) {
+			return []string{}, nil
+		}
+		return nil, errors.Wrap(ctx, err, "get lock candidates")
+	}
+
+	holders := []string{}
+	candidates, limit := lockCandidates(res.Node.Nodes)
+	if limit >= 1 && limit < len(candidates) {
+		candidates = candidates[:limit]
+	}
+	for _, node := range candidates {
+		var holder lockHolder
+		err := json.Unmarshal([]byte(node.Value), &holder)
+		if err != nil {
+			return nil, errors.Wrap(ctx, err, "unmarshal lock holder")
+		}
+		holders = append(holders, holder.UUID)
+	}
+	return holders, nil
+}
+
+// UUID returns the UUID of the lease holder. It has the same format as a host UUID: uuid-hostname
+func (l *Lease) UUID() string {
+	return l.uuid
+}
+
+// Done returns a channel which is closed once the lease is not held anymore
+func (l *Lease) Done() <-chan struct{} {
+	return l.doneChan
+}
+
+// Err returns nil while the lease is held or if it has been released with Release. Otherwise, it
+// returns the reason why the lease has been lost, like the cancellation of the context.
+func (l *Lease) Err() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.doneErr
+}
+
+// Release releases the lease so that another candidate can acquire it, without waiting for the
+// expiration of its key.
+func (l *Lease) Release(ctx context.Context) error {
+	l.cancel()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.doneChan:
+		return nil
+	}
+}
+
+// wait blocks until our candidate key is among the n first keys of the queue.
+func (l *Lease) wait(ctx context.Context, dir string, n int) error {
+	log := logger.Get(ctx)
+
+	for {
+		res, err := KAPI().Get(ctx, dir, &etcdv2.GetOptions{Sort: true})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.WithError(err).Errorf("Fail to get lock candidates (%v)", Client().Endpoints())
+			err = sleepOrDone(ctx, time.Second)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		candidates, _ := lockCandidates(res.Node.Nodes)
+		position := -1
+		for i, node := range candidates {
+			if node.Key == l.key {
+				position = i
+			}
+		}
+		if position == -1 {
+			return ErrLeaseLost
+		}
+		if position < n {
+			return nil
+		}
+
+		// Wait for any candidate to leave the queue before checking our position again.
+		watcher := KAPI().Watcher(dir, &etcdv2.WatcherOptions{AfterIndex: res.Index, Recursive: true})
+		for {
+			res, err := watcher.Next(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.WithError(err).Errorf("Lost watcher of '%s' (%v)", dir, Client().Endpoints())
+				break
+			}
+			if res.Action == "delete" || res.Action == "expire" || res.Action == "compareAndDelete" {
+				break
+			}
+		}
+	}
+}
+
+func (l *Lease) delete(ctx context.Context) {
+	ctx, cancel := withCleanupTimeout(ctx)
+	defer cancel()
+
+	_, err := KAPI().Delete(ctx, l.key, &etcdv2.DeleteOptions{PrevValue: l.value})
+	if err != nil && !etcdv2.IsKeyNotFound(err) && !isEtcdError(err, etcdv2.ErrorCodeTestFailed) {
+		logger.Get(ctx).WithError(err).Errorf("remove lock key %s", l.key)
+	}
+}
+
+func (l *Lease) signalDone(err error) {
+	l.signalDoneOnce.Do(func() {
+		l.mutex.Lock()
+		l.doneErr = err
+		l.mutex.Unlock()
+		close(l.doneChan)
+	})
+}
+
+// ensureLockCandidate keeps trying to queue a new candidate key in dir until it succeeds or the
+// context is canceled. It returns the candidate key and the time at which it has been created.
+func ensureLockCandidate(ctx context.Context, dir, holderJSON string, ttl time.Duration) (string, time.Time, error) {
+	log := logger.Get(ctx)
+
+	for {
+		queuedAt := time.Now()
+		res, err := KAPI().CreateInOrder(ctx, dir, holderJSON, &etcdv2.CreateInOrderOptions{TTL: ttl})
+		if err == nil {
+			return res.Node.Key, queuedAt, nil
+		}
+		if ctx.Err() != nil {
+			return "", time.Time{}, ctx.Err()
+		}
+		log.WithError(err).Errorf("Fail to queue lock candidate (%v)", Client().Endpoints())
+
+		err = sleepOrDone(ctx, time.Second)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+	}
+}
+
+// storeSemaphoreLimit keeps trying to store the limit of the semaphore in dir if it does not exist
+// yet, until it succeeds or the context is canceled. It returns ErrSemaphoreLimitMismatch if the
+// semaphore already exists with another limit.
+func storeSemaphoreLimit(ctx context.Context, dir string, n int) error {
+	log := logger.Get(ctx)
+	key := path.Join(dir, lockLimitKey)
+
+	for {
+		limit, err := getOrCreateSemaphoreLimit(ctx, key, n)
+		if err == nil {
+			if limit != strconv.Itoa(n) {
+				return fmt.Errorf("%w: %s", ErrSemaphoreLimitMismatch, limit)
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.WithError(err).Errorf("Fail to store semaphore limit (%v)", Client().Endpoints())
+
+		err = sleepOrDone(ctx, time.Second)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// getOrCreateSemaphoreLimit creates the limit key with n if it does not exist, and returns the
+// stored limit.
+func getOrCreateSemaphoreLimit(ctx context.Context, key string, n int) (string, error) {
+	_, err := KAPI().Set(ctx, key, strconv.Itoa(n), &etcdv2.SetOptions{PrevExist: etcdv2.PrevNoExist})
+	if err == nil {
+		return strconv.Itoa(n), nil
+	}
+	if !isEtcdError(err, etcdv2.ErrorCodeNodeExist) {
+		return "", errors.Wrap(ctx, err, "create limit key")
+	}
+
+	res, err := KAPI().Get(ctx, key, nil)
+	if err != nil {
+		return "", errors.Wrap(ctx, err, "get limit key")
+	}
+	return res.Node.Value, nil
+}
+
+// lockCandidates splits the nodes of a lock directory into the candidates, sorted by queue
+// order, and the limit of the semaphore. The limit is 0 if it is not stored.
+func lockCandidates(nodes etcdv2.Nodes) (etcdv2.Nodes, int) {
+	limit := 0
+	candidates := make(etcdv2.Nodes, 0, len(nodes))
+	for _, node := range nodes {
+		if path.Base(node.Key) == lockLimitKey {
+			limit, _ = strconv.Atoi(node.Value)
+			continue
+		}
+		candidates = append(candidates, node)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Key < candidates[j].Key
+	})
+	return candidates, limit
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock(t *testing.T) {
+	t.Run("With two candidates for the same lock", func(t *testing.T) {
+		lease1, err := Lock(t.Context(), "test_lock")
+		require.NoError(t, err)
+		assert.True(t, hasHostUUIDFormat(lease1.UUID()))
+
+		acquired := make(chan *Lease, 1)
+		go func() {
+			lease2, err := Lock(t.Context(), "test_lock")
+			assert.NoError(t, err)
+			acquired <- lease2
+		}()
+
+		t.Run("The second candidate should wait for the lock", func(t *testing.T) {
+			select {
+			case <-acquired:
+				t.Fatal("the lock has been acquired twice")
+			case <-time.After(500 * time.Millisecond):
+			}
+
+			holders, err := LockHolders(t.Context(), "test_lock")
+			require.NoError(t, err)
+			assert.Equal(t, []string{lease1.UUID()}, holders)
+		})
+
+		t.Run("The second candidate should acquire the lock once it is released", func(t *testing.T) {
+			require.NoError(t, lease1.Release(t.Context()))
+			require.NoError(t, lease1.Err())
+
+			var lease2 *Lease
+			select {
+			case lease2 = <-acquired:
+			case <-time.After(3 * time.Second):
+				t.Fatal("timed out waiting for the lock")
+			}
+			holders, err := LockHolders(t.Context(), "test_lock")
+			require.NoError(t, err)
+			assert.Equal(t, []string{lease2.UUID()}, holders)
+			require.NoError(t, lease2.Release(t.Context()))
+		})
+	})
+
+	t.Run("When the context is canceled, the lock should be released", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		lease, err := Lock(ctx, "test_lock_cancel")
+		require.NoError(t, err)
+
+		cancel()
+		select {
+		case <-lease.Done():
+		case <-time.After(3 * time.Second):
+			t.Fatal("timed out waiting for the lease to be released")
+		}
+		require.ErrorIs(t, lease.Err(), context.Canceled)
+
+		holders, err := LockHolders(t.Context(), "test_lock_cancel")
+		require.NoError(t, err)
+		assert.Empty(t, holders)
+	})
+}
+
+func TestSemaphore(t *testing.T) {
+	t.Run("With an invalid limit, it should return an error", func(t *testing.T) {
+		_, err := Semaphore(t.Context(), "test_semaphore_invalid", 0)
+		require.ErrorIs(t, err, ErrInvalidSemaphoreLimit)
+	})
+
+	t.Run("With an invalid TTL, it should return an error", func(t *testing.T) {
+		_, err := SemaphoreWithOptions(t.Context(), "test_semaphore_invalid", 1, LockOptions{TTL: 1500 * time.Millisecond})
+		require.ErrorIs(t, err, ErrInvalidLockOptions)
+	})
+
+	t.Run("With a custom TTL, the candidate key should have this TTL", func(t *testing.T) {
+		lease, err := SemaphoreWithOptions(t.Context(), "test_semaphore_ttl", 1, LockOptions{TTL: 2 * time.Second})
+		require.NoError(t, err)
+
+		res, err := KAPI().Get(t.Context(), lease.key, nil)
+		require.NoError(t, err)
+		assert.Positive(t, res.Node.TTL)
+		assert.LessOrEqual(t, res.Node.TTL, int64(2))
+		require.NoError(t, lease.Release(t.Context()))
+	})
+
+	t.Run("With another limit than the existing semaphore, it should return an error", func(t *testing.T) {
+		lease, err := Semaphore(t.Context(), "test_semaphore_mismatch", 2)
+		require.NoError(t, err)
+
+		_, err = Semaphore(t.Context(), "test_semaphore_mismatch", 3)
+		require.ErrorIs(t, err, ErrSemaphoreLimitMismatch)
+		_, err = Lock(t.Context(), "test_semaphore_mismatch")
+		require.ErrorIs(t, err, ErrSemaphoreLimitMismatch)
+		require.NoError(t, lease.Release(t.Context()))
+	})
+
+	t.Run("When etcd fails to store the limit, it should retry", func(t *testing.T) {
+		var puts atomic.Int32
+		useFakeEtcdServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if puts.Add(1) == 1 {
+				writeEtcdError(t, w)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Etcd-Index", "5")
+			w.WriteHeader(http.StatusCreated)
+			_, err := w.Write([]byte(`{"action":"create","node":{"key":"/locks/test_semaphore_retry/limit","value":"2","modifiedIndex":5,"createdIndex":5}}`))
+			assert.NoError(t, err)
+		})
+
+		err := storeSemaphoreLimit(t.Context(), "/locks/test_semaphore_retry", 2)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), puts.Load())
+	})
+
+	t.Run("With three candidates for a semaphore of two slots", func(t *testing.T) {
+		lease1, err := Semaphore(t.Context(), "test_semaphore", 2)
+		require.NoError(t, err)
+		lease2, err := Semaphore(t.Context(), "test_semaphore", 2)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(t.Context(), 500*time.Millisecond)
+		defer cancel()
+		_, err = Semaphore(ctx, "test_semaphore", 2)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		holders, err := LockHolders(t.Context(), "test_semaphore")
+		require.NoError(t, err)
+		assert.Equal(t, []string{lease1.UUID(), lease2.UUID()}, holders)
+
+		require.NoError(t, lease1.Release(t.Context()))
+		lease3, err := Semaphore(t.Context(), "test_semaphore", 2)
+		require.NoError(t, err)
+
+		holders, err = LockHolders(t.Context(), "test_semaphore")
+		require.NoError(t, err)
+		assert.Equal(t, []string{lease2.UUID(), lease3.UUID()}, holders)
+		require.NoError(t, lease2.Release(t.Context()))
+		require.NoError(t, lease3.Release(t.Context()))
+	})
+}
+
+func hasHostUUIDFormat(uuid string) bool {
+	return len(uuid) > 37 && uuid[36] == '-' && uuid[37:] == hostname
+}
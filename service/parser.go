@@ -3,22 +3,94 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"sync"
 
 	etcdv2 "go.etcd.io/etcd/client/v2"
 
 	"github.com/Scalingo/go-utils/errors/v3"
+	"github.com/Scalingo/go-utils/logger"
 )
 
-func buildHostsFromNodes(ctx context.Context, nodes etcdv2.Nodes) (Hosts, error) {
-	hosts := make(Hosts, len(nodes))
-	for i, node := range nodes {
+// SkippedNode is an etcd node which has been skipped because it is not a valid host.
+type SkippedNode struct {
+	// Key is the etcd key of the node
+	Key string
+	// Index is the etcd index the node has last been modified at
+	Index uint64
+	// Reason tells why the node is not a valid host
+	Reason string
+}
+
+var (
+	skippedNodesMutex   sync.Mutex
+	skippedNodesHandler func(service string, skipped []SkippedNode)
+	// reportedSkippedNodes are the etcd indexes of the skipped nodes which have been reported, by service
+	// and by key.
+	reportedSkippedNodes = map[string]map[string]uint64{}
+)
+
+// SetSkippedNodesHandler sets a function called when some nodes of a service are skipped because they are
+// not valid hosts, for instance to count them in a metric. A skipped node is logged and handed to the
+// handler once per modification, and not each time the hosts of the service are read.
+func SetSkippedNodesHandler(handler func(service string, skipped []SkippedNode)) {
+	skippedNodesMutex.Lock()
+	defer skippedNodesMutex.Unlock()
+	skippedNodesHandler = handler
+}
+
+// reportSkippedNodes logs the skipped nodes of a service which have not been reported at their current
+// index yet, and hands them to the skipped nodes handler. If all is true, skipped are all the skipped nodes
+// of the service, and the nodes which are not skipped anymore are forgotten.
+func reportSkippedNodes(ctx context.Context, service string, skipped []SkippedNode, all bool) {
+	skippedNodesMutex.Lock()
+	previous := reportedSkippedNodes[service]
+	current := previous
+	if all || current == nil {
+		current = make(map[string]uint64, len(skipped))
+	}
+	var unreported []SkippedNode
+	for _, node := range skipped {
+		index, ok := previous[node.Key]
+		if !ok || index != node.Index {
+			unreported = append(unreported, node)
+		}
+		current[node.Key] = node.Index
+	}
+	if len(current) == 0 {
+		delete(reportedSkippedNodes, service)
+	} else {
+		reportedSkippedNodes[service] = current
+	}
+	handler := skippedNodesHandler
+	skippedNodesMutex.Unlock()
+
+	if len(unreported) == 0 {
+		return
+	}
+
+	log := logger.Get(ctx)
+	for _, node := range unreported {
+		log.Errorf("Skip the invalid host '%s' of '%s': %s", node.Key, service, node.Reason)
+	}
+	if handler != nil {
+		handler(service, unreported)
+	}
+}
+
+// buildHostsFromNodes returns the hosts of the nodes. The nodes which are not valid hosts are skipped, so
+// that a single corrupt node does not make the whole service undiscoverable.
+func buildHostsFromNodes(ctx context.Context, nodes etcdv2.Nodes) (Hosts, []SkippedNode) {
+	hosts := make(Hosts, 0, len(nodes))
+	var skipped []SkippedNode
+	for _, node := range nodes {
 		host, err := buildHostFromNode(ctx, node)
 		if err != nil {
-			return nil, errors.Wrap(ctx, err, "build host from node")
+			skipped = append(skipped, SkippedNode{Key: node.Key, Index: node.ModifiedIndex, Reason: err.Error()})
+			continue
 		}
-		hosts[i] = host
+		hosts = append(hosts, host)
 	}
-	return hosts, nil
+	return hosts, skipped
 }
 
 func buildHostFromNode(ctx context.Context, node *etcdv2.Node) (*Host, error) {
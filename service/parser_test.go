@@ -47,12 +47,22 @@ var (
 
 func TestBuildHostsFromNodes(t *testing.T) {
 	t.Run("Given a sample response with 2 nodes, we got 2 hosts", func(t *testing.T) {
-		hosts, err := buildHostsFromNodes(t.Context(), sampleNodes)
-		require.NoError(t, err)
+		hosts, skipped := buildHostsFromNodes(t.Context(), sampleNodes)
+		assert.Empty(t, skipped)
 		assert.Len(t, hosts, 2)
 		assert.Equal(t, sampleResult, *hosts[0])
 		assert.Equal(t, sampleResult, *hosts[1])
 	})
+
+	t.Run("Given a corrupt node, it is skipped and reported", func(t *testing.T) {
+		corruptNode := &etcdv2.Node{Key: "/services/test/corrupt", Value: "{"}
+		hosts, skipped := buildHostsFromNodes(t.Context(), etcdv2.Nodes{sampleNode, corruptNode})
+		require.Len(t, hosts, 1)
+		assert.Equal(t, sampleResult, *hosts[0])
+		require.Len(t, skipped, 1)
+		assert.Equal(t, "/services/test/corrupt", skipped[0].Key)
+		assert.Contains(t, skipped[0].Reason, "unmarshal host")
+	})
 }
 
 func TestBuildHostFromNode(t *testing.T) {
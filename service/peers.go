@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	stderrors "errors"
+	"maps"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	etcdv2 "go.etcd.io/etcd/client/v2"
+
+	"github.com/Scalingo/go-utils/errors/v3"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// ErrNoRegisteredService is the error returned by Registration.Peers when the registration is not bound to a
+// service, like one built with NewRegistration.
+var ErrNoRegisteredService = stderrors.New("the registration has no service")
+
+// PeersChange is sent whenever the peers of a registration change.
+type PeersChange struct {
+	// Peers are all the peers after the change
+	Peers Hosts
+	// Version is the membership version after the change
+	Version uint64
+}
+
+// Peers follows the other hosts of the service of a registration. If the registered host is on a shard,
+// only the hosts of the same shard are peers.
+type Peers struct {
+	uuid    string
+	shard   string
+	changes chan PeersChange
+	mutex   sync.Mutex
+	// values are the etcd values of the peers by UUID
+	values  map[string]string
+	peers   Hosts
+	version uint64
+}
+
+// Peers returns the other hosts of the service of the registration, and follows them until ctx is
+// canceled. It first waits for the registration, as the shard of the host is only known once registered.
+func (w *Registration) Peers(ctx context.Context) (*Peers, error) {
+	if w.service == "" {
+		return nil, ErrNoRegisteredService
+	}
+
+	err := w.WaitRegistration(ctx)
+	if err != nil {
+		return nil, errors.Wrap(ctx, err, "wait for the registration")
+	}
+	shard := w.Shard()
+
+	serviceKey := "/services/" + w.service
+	p := &Peers{
+		uuid:    w.uuid,
+		shard:   shard,
+		changes: make(chan PeersChange, 1),
+		values:  nil,
+		peers:   Hosts{},
+		version: 0,
+	}
+
+	index, err := p.resync(ctx, serviceKey)
+	if err != nil {
+		return nil, err
+	}
+
+	go p.watch(ctx, serviceKey, index)
+	return p, nil
+}
+
+// Current returns the current peers and the membership version.
+func (p *Peers) Current() (Hosts, uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.peers, p.version
+}
+
+// Changes returns a channel receiving the peers each time they change. The membership version is increased
+// with each change. If the receiver is late, only the latest change is kept. The channel is closed once the
+// context given to Registration.Peers is canceled.
+func (p *Peers) Changes() <-chan PeersChange {
+	return p.changes
+}
+
+// resync reads all the hosts of the service, and returns the etcd index to watch the following modifications from.
+func (p *Peers) resync(ctx context.Context, serviceKey string) (uint64, error) {
+	res, err := KAPI().Get(ctx, serviceKey, &etcdv2.GetOptions{Recursive: true})
+	var etcdErr etcdv2.Error
+	if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+		p.update(ctx, map[string]string{})
+		return etcdErr.Index, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(ctx, err, "get service hosts")
+	}
+
+	values := map[string]string{}
+	for _, node := range res.Node.Nodes {
+		values[path.Base(node.Key)] = node.Value
+	}
+	p.update(ctx, values)
+	return res.Index, nil
+}
+
+func (p *Peers) watch(ctx context.Context, serviceKey string, index uint64) {
+	defer close(p.changes)
+	log := logger.Get(ctx)
+
+	resync := false
+	for {
+		if resync {
+			var err error
+			index, err = p.resync(ctx, serviceKey)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				log.WithError(err).Errorf("Fail to resync the peers of '%s' (%v)", serviceKey, Client().Endpoints())
+				if sleepOrDone(ctx, 1*time.Second) != nil {
+					return
+				}
+				continue
+			}
+			resync = false
+		}
+
+		watcher := KAPI().Watcher(serviceKey, &etcdv2.WatcherOptions{AfterIndex: index, Recursive: true})
+		res, err := watcher.Next(ctx)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if err != nil {
+			log.WithError(err).Errorf("Lost watcher of '%s' (%v)", serviceKey, Client().Endpoints())
+			resync = true
+			if sleepOrDone(ctx, 1*time.Second) != nil {
+				return
+			}
+			continue
+		}
+		index = res.Node.ModifiedIndex
+
+		p.mutex.Lock()
+		values := maps.Clone(p.values)
+		p.mutex.Unlock()
+
+		uuid := path.Base(res.Node.Key)
+		switch res.Action {
+		case "delete", "expire", "compareAndDelete":
+			delete(values, uuid)
+		default:
+			values[uuid] = res.Node.Value
+		}
+		p.update(ctx, values)
+	}
+}
+
+// update replaces the peers with the hosts described by values, and notifies the change if any. The
+// first update sets the initial peers, with the membership version 1.
+func (p *Peers) update(ctx context.Context, values map[string]string) {
+	log := logger.Get(ctx)
+
+	peerValues := map[string]string{}
+	peers := Hosts{}
+	for uuid, value := range values {
+		if uuid == p.uuid {
+			continue
+		}
+		host, err := buildHostFromNode(ctx, &etcdv2.Node{Value: value})
+		if err != nil {
+			log.WithError(err).Errorf("Invalid host '%s'", uuid)
+			continue
+		}
+		if host.Shard != p.shard || host.External {
+			continue
+		}
+		peerValues[uuid] = value
+		peers = append(peers, host)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].UUID < peers[j].UUID
+	})
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	changed := len(peerValues) != len(p.values)
+	for uuid, value := range peerValues {
+		if p.values[uuid] != value {
+			changed = true
+		}
+	}
+	if !changed && p.version > 0 {
+		return
+	}
+
+	p.values = peerValues
+	p.peers = peers
+	p.version++
+	if p.version == 1 {
+		// Initial peers, this is not a change
+		return
+	}
+
+	change := PeersChange{Peers: peers, Version: p.version}
+	// Only the latest change is kept for a late receiver
+	select {
+	case <-p.changes:
+	default:
+	}
+	p.changes <- change
+}
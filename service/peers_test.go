@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrationPeers(t *testing.T) {
+	host1 := genHost("test-peers-1")
+	host1.Shard = testShard1ID
+	w1 := registerForTest(t, t.Context(), "test_peers", host1, RegisterOptions{})
+	require.NoError(t, w1.WaitRegistration(t.Context()))
+
+	host2 := genHost("test-peers-2")
+	host2.Shard = testShard1ID
+	w2 := registerForTest(t, t.Context(), "test_peers", host2, RegisterOptions{})
+	require.NoError(t, w2.WaitRegistration(t.Context()))
+
+	host3 := genHost("test-peers-3")
+	host3.Shard = testShard2ID
+	w3 := registerForTest(t, t.Context(), "test_peers", host3, RegisterOptions{})
+	require.NoError(t, w3.WaitRegistration(t.Context()))
+
+	peers, err := w1.Peers(t.Context())
+	require.NoError(t, err)
+
+	t.Run("It should return the hosts of the same shard, except itself", func(t *testing.T) {
+		hosts, version := peers.Current()
+		require.Len(t, hosts, 1)
+		assert.Equal(t, w2.UUID(), hosts[0].UUID)
+		assert.Equal(t, uint64(1), version)
+	})
+
+	ctx, cancel := context.WithCancel(t.Context())
+	host4 := genHost("test-peers-4")
+	host4.Shard = testShard1ID
+	w4 := registerForTest(t, ctx, "test_peers", host4, RegisterOptions{})
+	require.NoError(t, w4.WaitRegistration(t.Context()))
+
+	t.Run("When a host joins, it should send the new peers", func(t *testing.T) {
+		change := receivePeersChange(t, peers)
+		assert.Equal(t, uint64(2), change.Version)
+		assert.ElementsMatch(t, []string{w2.UUID(), w4.UUID()}, hostUUIDs(change.Peers))
+	})
+
+	t.Run("When a host leaves, it should send the new peers", func(t *testing.T) {
+		cancel()
+		change := receivePeersChange(t, peers)
+		assert.Equal(t, uint64(3), change.Version)
+		assert.Equal(t, []string{w2.UUID()}, hostUUIDs(change.Peers))
+
+		hosts, version := peers.Current()
+		assert.Equal(t, change.Peers, hosts)
+		assert.Equal(t, uint64(3), version)
+	})
+}
+
+func TestRegistrationPeers_AutoShard(t *testing.T) {
+	opts := RegisterOptions{Shards: []string{"a"}}
+	w1 := registerForTest(t, t.Context(), "test_peers_auto_shard", genHost("test-peers-auto-shard-1"), opts)
+	require.NoError(t, w1.WaitRegistration(t.Context()))
+
+	t.Run("Before the registration, it should wait for the shard of the host", func(t *testing.T) {
+		w2 := registerForTest(t, t.Context(), "test_peers_auto_shard", genHost("test-peers-auto-shard-2"), opts)
+
+		peers, err := w2.Peers(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, "a", w2.Shard())
+
+		hosts, _ := peers.Current()
+		assert.Equal(t, []string{w1.UUID()}, hostUUIDs(hosts))
+	})
+
+	t.Run("Without a service, it should return an error", func(t *testing.T) {
+		w := NewRegistration(t.Context(), "test-peers-no-service", nil)
+
+		_, err := w.Peers(t.Context())
+		assert.ErrorIs(t, err, ErrNoRegisteredService)
+	})
+}
+
+func receivePeersChange(t *testing.T, peers *Peers) PeersChange {
+	t.Helper()
+
+	select {
+	case change := <-peers.Changes():
+		return change
+	case <-time.After(3 * time.Second):
+		t.Fatal("no peers change received")
+		return PeersChange{}
+	}
+}
+
+func hostUUIDs(hosts Hosts) []string {
+	uuids := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		uuids = append(uuids, host.UUID)
+	}
+	return uuids
+}
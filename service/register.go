@@ -3,7 +3,13 @@ package service
 import (
 	"context"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
@@ -23,45 +29,145 @@ const (
 	defaultRegistrationTimeout = 5 * time.Minute
 )
 
-// Register a host with a service name and a host description. The last chan is
-// a stop method. If something is written on this channel, any goroutines
-// launched by this method will stop.
+// ErrInvalidRegisterOptions is returned by Registration.Err when Register is called with invalid options.
+var ErrInvalidRegisterOptions = stderrors.New("invalid register options")
+
+// RegisterOptions configures a registration made with RegisterWithOptions. The zero value uses the
+// default configuration, as Register does.
+type RegisterOptions struct {
+	// TTL of the host key in etcd, which is a whole number of seconds. The host disappears from the
+	// service if it is not refreshed before. Defaults to 5 seconds.
+	TTL time.Duration
+	// RefreshInterval is the interval between two refreshes of the host key. It must be shorter than the
+	// TTL. Defaults to the TTL minus one second, or to half the TTL if the TTL is shorter than 2 seconds.
+	RefreshInterval time.Duration
+	// InitialRegistrationTimeout is the timeout of the first registration of the service and of the host.
+	// Defaults to the deadline of the Register context, or to 5 minutes if it has none.
+	InitialRegistrationTimeout time.Duration
+	// KeepOnStop keeps the host key once the Register context is canceled, instead of removing it. The
+	// host then disappears from the service once its TTL expires.
+	KeepOnStop bool
+	// AdvertiseCIDR is the network of the address advertised as PrivateHostname when the host has none,
+	// instead of the node hostname. The local interfaces are scanned for an address in this network.
+	// Defaults to the ETCD_DISCOVERY_ADVERTISE_CIDR environment variable.
+	AdvertiseCIDR string
+	// AdvertiseInterface restricts the scan of AdvertiseCIDR to a single interface. Without AdvertiseCIDR,
+	// the first global unicast address of this interface is advertised.
+	// Defaults to the ETCD_DISCOVERY_ADVERTISE_INTERFACE environment variable.
+	AdvertiseInterface string
+	// Shards are the shards among which the host is assigned automatically, if its Shard is empty. The host is
+	// assigned to the least populated one. The assigned shard is returned by Registration.Shard.
+	Shards []string
+	// ShardCount is an alternative to Shards, declaring the shards "shard-0" to "shard-<ShardCount-1>".
+	ShardCount int
+	// InstanceID is a stable identifier of the instance, which keeps the shard assigned automatically
+	// across restarts.
+	InstanceID string
+	// ShardReservationTTL is how long the shard assigned automatically is reserved for the host while it is
+	// not registered: between the assignment and the registration, and after the host has last been seen
+	// registered, so that an instance restarting with the same InstanceID within this delay gets its shard
+	// back. Defaults to 1 minute.
+	ShardReservationTTL time.Duration
+}
+
+// withDefaults returns the options with the default values set, or an error if the options are invalid.
+func (o RegisterOptions) withDefaults() (RegisterOptions, error) {
+	o = advertiseOptionsFromEnv(o)
+	if o.AdvertiseCIDR != "" {
+		_, _, err := net.ParseCIDR(o.AdvertiseCIDR)
+		if err != nil {
+			return o, fmt.Errorf("%w: invalid advertise network: %w", ErrInvalidRegisterOptions, err)
+		}
+	}
+
+	if o.TTL == 0 {
+		o.TTL = heartbeatTTL
+	}
+	if o.TTL < time.Second || o.TTL%time.Second != 0 {
+		return o, fmt.Errorf("%w: the TTL must be a whole number of seconds, got %s", ErrInvalidRegisterOptions, o.TTL)
+	}
+
+	if o.RefreshInterval == 0 {
+		o.RefreshInterval = defaultRefreshInterval(o.TTL)
+	}
+	if o.RefreshInterval < 0 || o.RefreshInterval >= o.TTL {
+		return o, fmt.Errorf("%w: the refresh interval (%s) must be shorter than the TTL (%s)", ErrInvalidRegisterOptions, o.RefreshInterval, o.TTL)
+	}
+
+	if len(o.Shards) > 0 && o.ShardCount != 0 {
+		return o, fmt.Errorf("%w: Shards and ShardCount are mutually exclusive", ErrInvalidRegisterOptions)
+	}
+	if o.ShardCount < 0 || slices.Contains(o.Shards, "") || slices.Contains(o.Shards, Unsharded) {
+		return o, fmt.Errorf("%w: invalid shards", ErrInvalidRegisterOptions)
+	}
+
+	if o.ShardReservationTTL == 0 {
+		o.ShardReservationTTL = defaultShardReservationTTL
+	}
+	if o.ShardReservationTTL < 0 {
+		return o, fmt.Errorf("%w: negative shard reservation TTL %s", ErrInvalidRegisterOptions, o.ShardReservationTTL)
+	}
+
+	if o.InitialRegistrationTimeout < 0 {
+		return o, fmt.Errorf("%w: negative initial registration timeout %s", ErrInvalidRegisterOptions, o.InitialRegistrationTimeout)
+	}
+	return o, nil
+}
+
+// Register a host with a service name and a host description. The registration
+// stops when the context is canceled.
 //
 // This service will launch two go routines. The first one will maintain the
-// registration every 5 seconds, and the second one will check if the service
-// credentials don't change and notify otherwise.
+// registration every RefreshInterval, and the second one will check if the service
+// credentials don't change and notify otherwise. The service infos are re-created if
+// they disappear, get corrupt or lose their shared credentials while the host is registered.
+//
+// The returned Registration is not ready while the host key cannot be refreshed.
+// Its Done channel is closed once the heartbeat and its watchers have stopped, and Err returns the reason.
 func Register(ctx context.Context, service string, host Host) *Registration {
-	if !host.Public && len(host.PrivateHostname) == 0 {
-		host.PrivateHostname = host.Hostname
-	}
+	return RegisterWithOptions(ctx, service, host, RegisterOptions{})
+}
 
-	if len(host.PrivateHostname) == 0 {
-		host.PrivateHostname = hostname
+// RegisterWithOptions is Register with custom options, see RegisterOptions.
+func RegisterWithOptions(ctx context.Context, service string, host Host, opts RegisterOptions) *Registration {
+	opts, optsErr := opts.withDefaults()
+	if optsErr == nil {
+		optsErr = host.validate()
 	}
-	host.Name = service
-
-	if len(host.PrivateHostname) != 0 && len(host.PrivatePorts) == 0 {
-		host.PrivatePorts = host.Ports
+	advertised := host.PrivateHostname != "" || !host.Public && host.Hostname != ""
+	if optsErr == nil && !advertised && (opts.AdvertiseCIDR != "" || opts.AdvertiseInterface != "") {
+		host.PrivateHostname, optsErr = advertiseAddress(opts.AdvertiseCIDR, opts.AdvertiseInterface)
 	}
 
+	host = hostLocalityFromEnv(host)
+	host = prepareHost(service, host)
+	hostUUID := host.UUID
+
 	ctx, log := logger.WithFieldsToCtx(ctx, logrus.Fields{
 		"hostname":     host.Hostname,
 		"service_name": host.Name,
 	})
 
-	uuidV4, _ := uuid.NewV4()
-	hostUUID := fmt.Sprintf("%s-%s", uuidV4.String(), host.PrivateHostname)
-	host.UUID = hostUUID
-
 	serviceInfos := &Service{
-		Name:     service,
-		Critical: host.Critical,
-		Public:   host.Public,
+		Name:             service,
+		Critical:         host.Critical,
+		Public:           host.Public,
+		CredentialsScope: host.CredentialsScope,
 	}
 
 	if host.Public {
 		serviceInfos.Hostname = host.Hostname
 		serviceInfos.Ports = host.Ports
+	}
+
+	sharedCredentials := host.CredentialsScope.withDefault(host.Public) == CredentialsScopeService
+	if host.GenerateCredentials && !sharedCredentials && host.User == "" && host.Password == "" {
+		// Each host generates its own credentials
+		credentials := generateCredentials()
+		host.User = credentials.User
+		host.Password = credentials.Password
+	}
+	if sharedCredentials {
 		serviceInfos.Password = host.Password
 		serviceInfos.User = host.User
 	}
@@ -73,72 +179,216 @@ func Register(ctx context.Context, service string, host Host) *Registration {
 	hostJSON, _ := json.Marshal(&host)
 	hostValue := string(hostJSON)
 
+	credentialsKey := hostCredentialsKey(service, hostUUID)
+
 	serviceKey := fmt.Sprintf("/services_infos/%s", service)
 	serviceJSON, _ := json.Marshal(serviceInfos)
 	serviceValue := string(serviceJSON)
 
 	registration := NewRegistration(ctx, hostUUID, publicCredentialsChan)
+	registration.service = service
+	registration.shard = host.Shard
+	registration.sharedCredentials = sharedCredentials
 
 	go func() {
-		ticker := time.NewTicker(heartbeatTTL - time.Second)
+		// The watchers are stopped along with the heartbeat, whatever the reason why it stops.
+		ctx, cancel := context.WithCancel(ctx)
+		var watchers sync.WaitGroup
+
+		// stopErr is the reason why the heartbeat stopped. It is reported by Registration.Err.
+		var stopErr error
+		defer func() {
+			cancel()
+			watchers.Wait()
+			registration.signalDone(stopErr)
+		}()
+		if optsErr != nil {
+			stopErr = optsErr
+			return
+		}
+
+		ticker := time.NewTicker(opts.RefreshInterval)
 		defer ticker.Stop()
 
+		initialCtx, cancelInitial := ctx, context.CancelFunc(func() {})
+		if opts.InitialRegistrationTimeout > 0 {
+			initialCtx, cancelInitial = context.WithTimeout(ctx, opts.InitialRegistrationTimeout)
+		}
+		defer cancelInitial()
+
 		// id is the current modification index of the service key.
 		// this is used for the watcher.
-		id, err := ensureServiceRegistration(ctx, serviceKey, serviceValue)
+		var (
+			id  uint64
+			err error
+		)
+		if host.GenerateCredentials && sharedCredentials {
+			id, err = ensureServiceRegistrationWithCredentials(initialCtx, serviceKey, serviceInfos)
+			// Adopt the credentials of the service, which may have been generated by another host
+			host.User = serviceInfos.User
+			host.Password = serviceInfos.Password
+			hostJSON, _ = json.Marshal(&host)
+			hostValue = string(hostJSON)
+		} else {
+			id, err = ensureServiceRegistration(initialCtx, serviceKey, serviceValue)
+		}
 		if err != nil {
-			registration.signalFailure(err)
+			stopErr = err
 			return
 		}
 		log.Info("Service registered in etcd")
 
-		err = ensureInitialHostRegistration(ctx, service, hostKey, hostValue, false)
+		if shards := opts.autoShards(); host.Shard == "" && len(shards) > 0 {
+			host.Shard, err = ensureShardAssignment(initialCtx, service, hostUUID, opts.InstanceID, shards, opts.ShardReservationTTL)
+			if err != nil {
+				stopErr = err
+				return
+			}
+			hostJSON, _ = json.Marshal(&host)
+			hostValue = string(hostJSON)
+			registration.setShard(host.Shard)
+			log.Infof("Host assigned to shard %s", host.Shard)
+		}
+
+		err = ensureInitialHostRegistration(initialCtx, service, hostKey, hostValue, opts.TTL)
 		if err != nil {
-			registration.signalFailure(err)
+			stopErr = err
 			return
 		}
 		log.Info("Host registered in etcd")
 
-		publicCredentialsChan <- Credentials{
+		credentials := Credentials{
 			User:     serviceInfos.User,
 			Password: serviceInfos.Password,
 		}
-
-		if host.Public {
-			go watch(ctx, serviceKey, id, privateCredentialsChan)
+		if !sharedCredentials {
+			// Each host has its own credentials
+			credentials = Credentials{
+				User:     host.User,
+				Password: host.Password,
+			}
 		}
+		publicCredentialsChan <- credentials
+
+		// The service infos are watched to repair them if they disappear, and to sync the shared credentials
+		repairChan := make(chan struct{}, 1)
+		repairPending := false
+		if sharedCredentials {
+			watchers.Go(func() { watch(ctx, serviceKey, id, serviceInfos, credentials, privateCredentialsChan, repairChan) })
+		} else {
+			watchers.Go(func() { watch(ctx, serviceKey, id, serviceInfos, credentials, nil, repairChan) })
+			watchers.Go(func() { watchHostCredentials(ctx, credentialsKey, id, credentials, privateCredentialsChan) })
+		}
+
+		// The host key is watched to re-register the host as soon as it is removed or overwritten by another writer
+		hostKeyCheckChan := make(chan struct{}, 1)
+		watchers.Go(func() { watchHostKey(ctx, hostKey, id, hostKeyCheckChan) })
 
 		for {
 			select {
 			case <-ctx.Done():
-				_, err := KAPI().Delete(ctx, hostKey, &etcdv2.DeleteOptions{Recursive: false})
-				if err != nil {
-					log.WithError(err).Errorf("remove host key %s", hostKey)
+				cleanupCtx, cancel := withCleanupTimeout(ctx)
+				if !opts.KeepOnStop {
+					_, err := KAPI().Delete(cleanupCtx, hostKey, &etcdv2.DeleteOptions{Recursive: false})
+					if err != nil {
+						log.WithError(err).Errorf("remove host key %s", hostKey)
+					}
 				}
+				if !sharedCredentials {
+					_, err := KAPI().Delete(cleanupCtx, credentialsKey, &etcdv2.DeleteOptions{Recursive: false})
+					if err != nil && !etcdv2.IsKeyNotFound(err) {
+						log.WithError(err).Errorf("remove host credentials key %s", credentialsKey)
+					}
+				}
+				cancel()
+				stopErr = ctx.Err()
 				return
 			case credentials := <-privateCredentialsChan: // If the credentials have been changed,
 				// We update our cache
 				host.User = credentials.User
 				host.Password = credentials.Password
-				serviceInfos.User = credentials.User
-				serviceInfos.Password = credentials.Password
+				if sharedCredentials {
+					serviceInfos.User = credentials.User
+					serviceInfos.Password = credentials.Password
+				}
 
 				// Re-marshal the host
 				hostJSON, _ = json.Marshal(&host)
 				hostValue = string(hostJSON)
 
 				// Sync the host information
-				err := ensureHostRegistration(ctx, service, hostKey, hostValue, true)
+				err := ensureHostRegistration(ctx, service, hostKey, hostValue, opts.TTL, registration)
 				if err != nil {
+					stopErr = errors.Wrap(ctx, err, "sync host credentials")
 					return
 				}
+				if !sharedCredentials {
+					// The rotated credentials expire along with the host key
+					err := refreshHostCredentials(ctx, credentialsKey, opts.TTL)
+					if err != nil {
+						log.WithError(err).Errorf("Fail to refresh the host credentials %s", credentialsKey)
+					}
+				}
 				// and transmit them to the client
 				publicCredentialsChan <- credentials
+			case <-hostKeyCheckChan:
+				eventType, err := checkHostKey(ctx, service, hostUUID, hostKey, hostValue)
+				if err != nil {
+					// The host key is checked again once the watcher is resynced
+					log.WithError(err).Errorf("Fail to check the host key %s", hostKey)
+					continue
+				}
+				if eventType == "" {
+					continue
+				}
+				log.Infof("Host key %s has been %s", hostKey, eventType)
+
+				if eventType == RegistrationEventEvicted {
+					// The host key may have been re-created by a heartbeat since the eviction
+					_, err := KAPI().Delete(ctx, hostKey, &etcdv2.DeleteOptions{Recursive: false})
+					if err != nil && !etcdv2.IsKeyNotFound(err) {
+						log.WithError(err).Errorf("remove host key %s", hostKey)
+					}
+					if !sharedCredentials {
+						_, err := KAPI().Delete(ctx, credentialsKey, &etcdv2.DeleteOptions{Recursive: false})
+						if err != nil && !etcdv2.IsKeyNotFound(err) {
+							log.WithError(err).Errorf("remove host credentials key %s", credentialsKey)
+						}
+					}
+					registration.signalEvent(RegistrationEvent{Type: eventType})
+					stopErr = ErrEvicted
+					return
+				}
+
+				err = ensureHostRegistration(ctx, service, hostKey, hostValue, opts.TTL, registration)
+				if err != nil {
+					stopErr = errors.Wrap(ctx, err, "re-register host")
+					return
+				}
+				registration.signalEvent(RegistrationEvent{Type: eventType})
+			case <-repairChan:
+				err := repairServiceInfos(ctx, serviceKey, serviceInfos, sharedCredentials, opts.TTL)
+				if err != nil {
+					// The repair is retried on the next heartbeat
+					log.WithError(err).Errorf("Fail to repair the service infos %s", serviceKey)
+					repairPending = true
+				}
 			case <-ticker.C:
-				err := ensureHostRegistration(ctx, service, hostKey, hostValue, true)
+				err := ensureHostRegistration(ctx, service, hostKey, hostValue, opts.TTL, registration)
 				if err != nil {
+					stopErr = errors.Wrap(ctx, err, "refresh host registration")
 					return
 				}
+				if !sharedCredentials {
+					err := refreshHostCredentials(ctx, credentialsKey, opts.TTL)
+					if err != nil {
+						log.WithError(err).Errorf("Fail to refresh the host credentials %s", credentialsKey)
+					}
+				}
+				if repairPending {
+					repairPending = false
+					notify(repairChan)
+				}
 			}
 		}
 	}()
@@ -146,13 +396,84 @@ func Register(ctx context.Context, service string, host Host) *Registration {
 	return registration
 }
 
-func watch(ctx context.Context, serviceKey string, id uint64, credentialsChan chan Credentials) {
+// prepareHost fills the host fields which are deduced from the service name and from the node hostname,
+// and generates a new host UUID.
+func prepareHost(service string, host Host) Host {
+	if !host.Public && len(host.PrivateHostname) == 0 {
+		host.PrivateHostname = host.Hostname
+	}
+
+	if len(host.PrivateHostname) == 0 {
+		host.PrivateHostname = hostname
+	}
+	host.Name = service
+	host.External = false
+
+	if len(host.PrivateHostname) != 0 && len(host.PrivatePorts) == 0 {
+		host.PrivatePorts = host.Ports
+	}
+
+	host.UUID = newHostUUID(host.PrivateHostname)
+	// Round strips the monotonic clock reading, which is not marshaled
+	host.RegisteredAt = time.Now().UTC().Round(0)
+	return host
+}
+
+// newHostUUID generates a host UUID with the following pattern: uuid-hostname
+func newHostUUID(hostname string) string {
+	uuidV4, _ := uuid.NewV4()
+	return fmt.Sprintf("%s-%s", uuidV4.String(), hostname)
+}
+
+// watch notifies credentialsChan whenever the credentials stored in the service infos change.
+// credentials are the credentials currently used by the registration. credentialsChan is nil if the
+// credentials are not shared by the hosts of the service.
+//
+// repairChan is notified whenever the service infos are missing, corrupt or lost the shared credentials.
+// The service infos which diverged from expectedInfos, the service infos written by this host, are only
+// logged: the hosts of a service may legitimately differ, for instance during a rolling update.
+func watch(ctx context.Context, serviceKey string, id uint64, expectedInfos *Service, credentials Credentials, credentialsChan chan Credentials, repairChan chan struct{}) {
 	log := logger.Get(ctx)
 
 	// id is the index of the last modification made to the key. The watcher will
 	// start watching for modifications done after this index. This will prevent
 	// packet or modification lost.
+	resync := false
 	for {
+		if resync {
+			// Modifications may have been missed while the watcher was lost, read the current service infos.
+			index, serviceInfos, err := getServiceInfos(ctx, serviceKey)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				log.WithError(err).Errorf("Fail to resync the watcher of '%s' (%v)", serviceKey, Client().Endpoints())
+				if sleepOrDone(ctx, 1*time.Second) != nil {
+					return
+				}
+				continue
+			}
+			id = index
+			resync = false
+
+			if !isServiceInfosHealthy(serviceInfos, credentialsChan != nil) {
+				notify(repairChan)
+				continue
+			}
+			logServiceInfosDivergence(ctx, serviceKey, serviceInfos, expectedInfos)
+			if credentialsChan != nil {
+				currentCredentials := Credentials{User: serviceInfos.User, Password: serviceInfos.Password}
+				if currentCredentials != credentials {
+					credentials = currentCredentials
+					select {
+					case <-ctx.Done():
+						return
+					case credentialsChan <- credentials:
+					}
+				}
+			}
+		}
+
 		watcher := KAPI().Watcher(serviceKey, &etcdv2.WatcherOptions{
 			AfterIndex: id,
 		})
@@ -162,27 +483,161 @@ func watch(ctx context.Context, serviceKey string, id uint64, credentialsChan ch
 		}
 
 		if err != nil {
-			// We've lost the connexion to etcd. Sleep 1s and retry
+			// We've lost the connexion to etcd, or the index we're watching from has been cleared. Sleep 1s and retry
 			log.WithError(err).Errorf("Lost watcher of '%s' (%v)", serviceKey, Client().Endpoints())
-			id = 0
-			time.Sleep(1 * time.Second)
+			resync = true
+			if sleepOrDone(ctx, 1*time.Second) != nil {
+				return
+			}
 			continue
 		}
-		var serviceInfos Service
-		err = json.Unmarshal([]byte(resp.Node.Value), &serviceInfos)
+		id = resp.Node.ModifiedIndex
+
+		if resp.Action == "delete" || resp.Action == "expire" {
+			log.Errorf("Service key '%s' has been removed", serviceKey)
+			notify(repairChan)
+			continue
+		}
+
+		serviceInfos, err := buildServiceFromNode(ctx, resp.Node)
 		if err != nil {
+			serviceInfos = nil
+		}
+		if !isServiceInfosHealthy(serviceInfos, credentialsChan != nil) {
 			log.WithError(err).Errorf(
-				"Error while getting service key '%s' (%v)",
+				"Invalid service key '%s' (%v)",
 				serviceKey, Client().Endpoints(),
 			)
-			time.Sleep(1 * time.Second)
+			notify(repairChan)
+			continue
 		}
-		// We've got the modification, send it to the register agent
-		id = resp.Node.ModifiedIndex
-		credentialsChan <- Credentials{
+		logServiceInfosDivergence(ctx, serviceKey, serviceInfos, expectedInfos)
+		if credentialsChan == nil {
+			continue
+		}
+
+		newCredentials := Credentials{
 			User:     serviceInfos.User,
 			Password: serviceInfos.Password,
 		}
+		if newCredentials == credentials {
+			continue
+		}
+
+		// We've got the modification, send it to the register agent
+		credentials = newCredentials
+		select {
+		case <-ctx.Done():
+			return
+		case credentialsChan <- credentials:
+		}
+	}
+}
+
+// getServiceInfos returns the service infos, and the etcd index to watch the following modifications from.
+// The service infos are nil if they are missing or corrupt.
+func getServiceInfos(ctx context.Context, serviceKey string) (uint64, *Service, error) {
+	res, err := KAPI().Get(ctx, serviceKey, nil)
+	if err != nil {
+		var etcdErr etcdv2.Error
+		if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+			return etcdErr.Index, nil, nil
+		}
+		return 0, nil, errors.Wrap(ctx, err, "get service infos")
+	}
+
+	serviceInfos, err := buildServiceFromNode(ctx, res.Node)
+	if err != nil {
+		logger.Get(ctx).WithError(err).Errorf("Invalid service key '%s'", serviceKey)
+		return res.Index, nil, nil
+	}
+
+	return res.Index, serviceInfos, nil
+}
+
+// isServiceInfosHealthy returns false if the service infos are missing or corrupt, or if they lost the
+// credentials shared by the hosts of the service.
+func isServiceInfosHealthy(serviceInfos *Service, sharedCredentials bool) bool {
+	if serviceInfos == nil {
+		return false
+	}
+	return !sharedCredentials || serviceInfos.User != "" || serviceInfos.Password != ""
+}
+
+// logServiceInfosDivergence logs the fields owned by the hosts which differ between the service infos and
+// expectedInfos. The credentials are not compared since they can be rotated.
+func logServiceInfosDivergence(ctx context.Context, serviceKey string, serviceInfos, expectedInfos *Service) {
+	var fields []string
+	if serviceInfos.Critical != expectedInfos.Critical {
+		fields = append(fields, "critical")
+	}
+	if serviceInfos.Public != expectedInfos.Public {
+		fields = append(fields, "public")
+	}
+	if serviceInfos.Hostname != expectedInfos.Hostname {
+		fields = append(fields, "hostname")
+	}
+	if !maps.Equal(serviceInfos.Ports, expectedInfos.Ports) {
+		fields = append(fields, "ports")
+	}
+	if len(fields) > 0 {
+		logger.Get(ctx).Warnf("Service key '%s' differs from the registered host on %s", serviceKey, strings.Join(fields, ", "))
+	}
+}
+
+// notify sends a notification on c without blocking: a single pending notification is enough.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// repairServiceInfos re-creates the service infos from serviceInfos if they are missing or corrupt, and
+// restores the credentials of serviceInfos if they lost the shared credentials. The other fields of valid
+// service infos are kept. All the writes are conditional so that the hosts of the service repairing the
+// service infos concurrently, or an operator fixing them, never overwrite each other. The repair is
+// aborted after timeout.
+func repairServiceInfos(ctx context.Context, serviceKey string, serviceInfos *Service, sharedCredentials bool, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		repairedInfos := *serviceInfos
+		res, err := KAPI().Get(ctx, serviceKey, nil)
+		if err != nil && !isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+			return errors.Wrap(ctx, err, "get service infos")
+		}
+
+		setOpts := &etcdv2.SetOptions{PrevExist: etcdv2.PrevNoExist}
+		if err == nil {
+			currentInfos, err := buildServiceFromNode(ctx, res.Node)
+			if err != nil {
+				currentInfos = nil
+			}
+			if isServiceInfosHealthy(currentInfos, sharedCredentials) {
+				// Someone else repaired them in the meantime
+				return nil
+			}
+			if currentInfos != nil {
+				// Only the credentials are lost
+				repairedInfos = *currentInfos
+				repairedInfos.User = serviceInfos.User
+				repairedInfos.Password = serviceInfos.Password
+			}
+			setOpts = &etcdv2.SetOptions{PrevIndex: res.Node.ModifiedIndex}
+		}
+
+		serviceJSON, _ := json.Marshal(&repairedInfos)
+		_, err = KAPI().Set(ctx, serviceKey, string(serviceJSON), setOpts)
+		if isEtcdError(err, etcdv2.ErrorCodeNodeExist) || isEtcdError(err, etcdv2.ErrorCodeTestFailed) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(ctx, err, "repair service infos")
+		}
+		logger.Get(ctx).Infof("Service key '%s' repaired", serviceKey)
+		return nil
 	}
 }
 
@@ -208,33 +663,132 @@ func ensureServiceRegistration(ctx context.Context, serviceKey, serviceJSON stri
 	return id, nil
 }
 
-func hostRegistration(ctx context.Context, hostKey, hostJSON string) error {
-	_, err := KAPI().Set(ctx, hostKey, hostJSON, &etcdv2.SetOptions{TTL: heartbeatTTL})
+// ensureServiceRegistrationWithCredentials keeps retrying the registration of the service infos, keeping
+// the credentials already stored in etcd, until it succeeds or the context is canceled.
+// serviceInfos is updated with the credentials of the service.
+func ensureServiceRegistrationWithCredentials(ctx context.Context, serviceKey string, serviceInfos *Service) (uint64, error) {
+	ctx, cancel := withDefaultRegistrationTimeout(ctx)
+	defer cancel()
+
+	id, err := serviceRegistrationWithCredentials(ctx, serviceKey, serviceInfos)
+	for err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(1 * time.Second):
+		}
+
+		id, err = serviceRegistrationWithCredentials(ctx, serviceKey, serviceInfos)
+	}
+
+	return id, nil
+}
+
+func hostRegistration(ctx context.Context, hostKey, hostJSON string, ttl time.Duration) error {
+	_, err := KAPI().Set(ctx, hostKey, hostJSON, &etcdv2.SetOptions{TTL: ttl})
 	if err != nil {
 		return errors.Wrap(ctx, err, "register host")
 	}
 	return nil
 }
 
-func ensureInitialHostRegistration(ctx context.Context, service, hostKey, hostJSON string, logFailures bool) error {
+// keepAlive refreshes the TTL of a key until the context is canceled. refreshedAt is the time at
+// which the key was last written with this TTL.
+//
+// The refresh is a compare-and-swap on value, so that a key which has expired and has been
+// re-created by another writer in the meantime is never extended. keepAlive returns an error
+// once the key is lost: when it has been removed or modified by another writer, or as soon as
+// it may have expired because it could not be refreshed within its TTL. Each refresh request
+// is therefore bounded by the remaining TTL.
+func keepAlive(ctx context.Context, key, value string, ttl time.Duration, refreshedAt time.Time) error {
+	ticker := time.NewTicker(defaultRefreshInterval(ttl))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		for {
+			startedAt := time.Now()
+			err := refreshKey(ctx, key, value, ttl, refreshedAt.Add(ttl).Sub(startedAt))
+			if err == nil {
+				refreshedAt = startedAt
+				break
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) || isEtcdError(err, etcdv2.ErrorCodeTestFailed) {
+				return err
+			}
+
+			expiresIn := time.Until(refreshedAt.Add(ttl))
+			if expiresIn <= 0 {
+				return errors.Wrap(ctx, err, "key expired")
+			}
+			err = sleepOrDone(ctx, min(time.Second, expiresIn))
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// refreshKey refreshes the TTL of a key if it still has the given value. The request is aborted
+// after timeout.
+func refreshKey(ctx context.Context, key, value string, ttl, timeout time.Duration) error {
+	refreshCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := KAPI().Set(refreshCtx, key, "", &etcdv2.SetOptions{
+		TTL:       ttl,
+		PrevValue: value,
+		Refresh:   true,
+	})
+	if err != nil {
+		return errors.Wrap(ctx, err, "refresh key")
+	}
+	return nil
+}
+
+// defaultRefreshInterval returns the interval at which a key with the given TTL is refreshed
+func defaultRefreshInterval(ttl time.Duration) time.Duration {
+	if ttl < 2*time.Second {
+		return ttl / 2
+	}
+	return ttl - time.Second
+}
+
+func ensureInitialHostRegistration(ctx context.Context, service, hostKey, hostJSON string, ttl time.Duration) error {
 	registrationCtx, cancel := withDefaultRegistrationTimeout(ctx)
 	defer cancel()
 
-	return ensureHostRegistration(registrationCtx, service, hostKey, hostJSON, logFailures)
+	return ensureHostRegistration(registrationCtx, service, hostKey, hostJSON, ttl, nil)
 }
 
 // ensureHostRegistration keeps retrying the host registration until it succeeds or the context is canceled.
-func ensureHostRegistration(ctx context.Context, service, hostKey, hostJSON string, logFailures bool) error {
+//
+// registration is nil for the initial registration. Otherwise, the failures are logged and the
+// registration is flagged as lost until etcd accepts the host again.
+func ensureHostRegistration(ctx context.Context, service, hostKey, hostJSON string, ttl time.Duration, registration *Registration) error {
 	log := logger.Get(ctx)
 
-	err := hostRegistration(ctx, hostKey, hostJSON)
+	err := hostRegistration(ctx, hostKey, hostJSON, ttl)
 	for err != nil {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
 
-		if logFailures {
+		if registration != nil {
 			log.WithError(err).Errorf("Lost registration of '%s' (%v)", service, Client().Endpoints())
+			registration.signalLost()
 		}
 
 		// Wait for either context cancellation or the next retry attempt.
@@ -244,9 +798,10 @@ func ensureHostRegistration(ctx context.Context, service, hostKey, hostJSON stri
 		case <-time.After(1 * time.Second):
 		}
 
-		err = hostRegistration(ctx, hostKey, hostJSON)
-		if err == nil && logFailures {
+		err = hostRegistration(ctx, hostKey, hostJSON, ttl)
+		if err == nil && registration != nil {
 			log.Infof("Recover registration of '%s'", service)
+			registration.signalRecovered()
 		}
 	}
 
@@ -262,6 +817,23 @@ func withDefaultRegistrationTimeout(ctx context.Context) (context.Context, conte
 	return context.WithTimeout(ctx, defaultRegistrationTimeout)
 }
 
+// withCleanupTimeout returns a context which is not canceled with ctx, used to clean up the etcd keys
+// once ctx has been canceled.
+func withCleanupTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), heartbeatTTL)
+}
+
+// sleepOrDone waits for the given duration, or returns the context error if the context is
+// canceled before.
+func sleepOrDone(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
 func serviceRegistration(ctx context.Context, serviceKey, serviceJSON string) (uint64, error) {
 	key, err := KAPI().Set(ctx, serviceKey, serviceJSON, nil)
 	if err != nil {
@@ -9,6 +9,7 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -22,7 +23,7 @@ func TestRegister(t *testing.T) {
 		host := genHost("test-register")
 		t.Run("It should be available with etcd", func(t *testing.T) {
 			host.Name = "test_register"
-			w := Register(t.Context(), "test_register", host)
+			w := registerForTest(t, t.Context(), "test_register", host, RegisterOptions{})
 			require.NoError(t, w.WaitRegistration(t.Context()))
 			uuid := w.UUID()
 			res, err := KAPI().Get(t.Context(), "/services/test_register/"+uuid, &etcdv2.GetOptions{})
@@ -34,11 +35,13 @@ func TestRegister(t *testing.T) {
 
 			assert.Equal(t, uuid, path.Base(res.Node.Key))
 			host.UUID = h.UUID
+			assert.False(t, h.RegisteredAt.IsZero())
+			host.RegisteredAt = h.RegisteredAt
 			assert.Equal(t, host, *h)
 		})
 
 		t.Run(fmt.Sprintf("And the ttl must be <= %s", heartbeatTTL), func(t *testing.T) {
-			w := Register(t.Context(), "test2_register", host)
+			w := registerForTest(t, t.Context(), "test2_register", host, RegisterOptions{})
 			require.NoError(t, w.WaitRegistration(t.Context()))
 			uuid := w.UUID()
 			res, err := KAPI().Get(t.Context(),
@@ -63,7 +66,7 @@ func TestRegister(t *testing.T) {
 				Public:   true,
 				Critical: true,
 			}
-			w := Register(t.Context(), "test3_register", host)
+			w := registerForTest(t, t.Context(), "test3_register", host, RegisterOptions{})
 			require.NoError(t, w.WaitRegistration(t.Context()))
 			res, err := KAPI().Get(t.Context(), "/services_infos/test3_register", &etcdv2.GetOptions{})
 			require.NoError(t, err)
@@ -78,7 +81,7 @@ func TestRegister(t *testing.T) {
 			hostWithShard := genHost("test-shard")
 			hostWithShard.Shard = "shard-0"
 
-			w := Register(t.Context(), "test5_register", hostWithShard)
+			w := registerForTest(t, t.Context(), "test5_register", hostWithShard, RegisterOptions{})
 			require.NoError(t, w.WaitRegistration(t.Context()))
 
 			res, err := KAPI().Get(t.Context(), "/services_infos/test5_register", &etcdv2.GetOptions{})
@@ -99,7 +102,7 @@ func TestRegister(t *testing.T) {
 			hostWithoutShard := genHost("test-no-shard")
 			hostWithoutShard.Shard = ""
 
-			w := Register(t.Context(), "test6_register", hostWithoutShard)
+			w := registerForTest(t, t.Context(), "test6_register", hostWithoutShard, RegisterOptions{})
 			require.NoError(t, w.WaitRegistration(t.Context()))
 
 			resService, err := KAPI().Get(t.Context(), "/services_infos/test6_register", &etcdv2.GetOptions{})
@@ -124,7 +127,7 @@ func TestRegister(t *testing.T) {
 		t.Run("After cancelling context, the service should disappear", func(t *testing.T) {
 			ctx, cancel := context.WithCancel(t.Context())
 			host := genHost("test-disappear")
-			w := Register(ctx, "test4_register", host)
+			w := registerForTest(t, ctx, "test4_register", host, RegisterOptions{})
 			require.NoError(t, w.WaitRegistration(t.Context()))
 			hostKey := "/services/test4_register/" + w.UUID()
 			cancel()
@@ -132,19 +135,27 @@ func TestRegister(t *testing.T) {
 				_, err := KAPI().Get(t.Context(), hostKey, &etcdv2.GetOptions{})
 				return etcdv2.IsKeyNotFound(err)
 			}, heartbeatTTL+2*time.Second, 100*time.Millisecond)
+
+			select {
+			case <-w.Done():
+			case <-time.After(time.Second):
+				t.Fatal("timed out waiting for the registration to be done")
+			}
+			require.ErrorIs(t, w.Err(), context.Canceled)
+			assert.False(t, w.Ready())
 		})
 
 		t.Run("When the private_hostname is not set, it must take the node hostname", func(t *testing.T) {
 			host := genHost("HelloWorld")
 			host.PrivateHostname = ""
-			w := Register(t.Context(), "hello_world", host)
+			w := registerForTest(t, t.Context(), "hello_world", host, RegisterOptions{})
 			assert.True(t, strings.HasSuffix(w.UUID(), hostname))
 		})
 		t.Run("When the private ports is not set and the service is private, it should take the public_ports", func(t *testing.T) {
 			host := genHost("HelloWorld2")
 			host.Public = false
 			host.PrivatePorts = Ports{}
-			w := Register(t.Context(), "hello_world2", host)
+			w := registerForTest(t, t.Context(), "hello_world2", host, RegisterOptions{})
 			require.NoError(t, w.WaitRegistration(t.Context()))
 			h, err := Get(t.Context(), "hello_world2").First(t.Context()).Host(t.Context())
 			require.NoError(t, err)
@@ -154,6 +165,64 @@ func TestRegister(t *testing.T) {
 	})
 }
 
+func TestRegisterOptions(t *testing.T) {
+	t.Run("With a custom TTL, the host key should expire after this TTL", func(t *testing.T) {
+		host := genHost("test-register-options-ttl")
+		w := registerForTest(t, t.Context(), "test_register_options_ttl", host, RegisterOptions{TTL: 2 * time.Second})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		res, err := KAPI().Get(t.Context(), "/services/test_register_options_ttl/"+w.UUID(), &etcdv2.GetOptions{})
+		require.NoError(t, err)
+		assert.LessOrEqual(t, res.Node.Expiration.Sub(time.Now()), 2*time.Second)
+
+		// The host key must be refreshed before it expires
+		time.Sleep(3 * time.Second)
+		_, err = KAPI().Get(t.Context(), "/services/test_register_options_ttl/"+w.UUID(), &etcdv2.GetOptions{})
+		require.NoError(t, err)
+		assert.True(t, w.Ready())
+	})
+
+	t.Run("With KeepOnStop, the host key should stay after cancelling the context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		host := genHost("test-register-options-keep")
+		w := registerForTest(t, ctx, "test_register_options_keep", host, RegisterOptions{KeepOnStop: true})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+		cancel()
+		<-w.Done()
+
+		_, err := KAPI().Get(t.Context(), "/services/test_register_options_keep/"+w.UUID(), &etcdv2.GetOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("With invalid options, the registration should stop", func(t *testing.T) {
+		for name, opts := range map[string]RegisterOptions{
+			"a TTL which is not a whole number of seconds": {TTL: 1500 * time.Millisecond},
+			"a refresh interval longer than the TTL":       {TTL: 2 * time.Second, RefreshInterval: 3 * time.Second},
+			"a refresh interval equal to the default TTL":  {RefreshInterval: heartbeatTTL},
+			"a negative initial registration timeout":      {InitialRegistrationTimeout: -time.Second},
+		} {
+			t.Run(name, func(t *testing.T) {
+				w := registerForTest(t, t.Context(), "test_register_options_invalid", genHost("test-register-options-invalid"), opts)
+				err := w.WaitRegistration(t.Context())
+				require.ErrorIs(t, err, ErrInvalidRegisterOptions)
+				<-w.Done()
+				require.ErrorIs(t, w.Err(), ErrInvalidRegisterOptions)
+			})
+		}
+	})
+
+	t.Run("Default options", func(t *testing.T) {
+		opts, err := RegisterOptions{}.withDefaults()
+		require.NoError(t, err)
+		assert.Equal(t, heartbeatTTL, opts.TTL)
+		assert.Equal(t, heartbeatTTL-time.Second, opts.RefreshInterval)
+
+		opts, err = RegisterOptions{TTL: time.Second}.withDefaults()
+		require.NoError(t, err)
+		assert.Equal(t, 500*time.Millisecond, opts.RefreshInterval)
+	})
+}
+
 func TestWatcher(t *testing.T) {
 	t.Run("With two instances of the same service", func(t *testing.T) {
 		host1 := genHost("test-watcher-1")
@@ -165,7 +234,7 @@ func TestWatcher(t *testing.T) {
 		host2.User = "host2"
 		host2.Password = "password2"
 
-		w1 := Register(t.Context(), "test-watcher", host1)
+		w1 := registerForTest(t, t.Context(), "test-watcher", host1, RegisterOptions{})
 		require.NoError(t, w1.WaitRegistration(t.Context()))
 
 		cred1, err := w1.Credentials()
@@ -173,7 +242,7 @@ func TestWatcher(t *testing.T) {
 		assert.Equal(t, "host1", cred1.User)
 		assert.Equal(t, "password1", cred1.Password)
 
-		w2 := Register(t.Context(), "test-watcher", host2)
+		w2 := registerForTest(t, t.Context(), "test-watcher", host2, RegisterOptions{})
 		require.NoError(t, w2.WaitRegistration(t.Context()))
 
 		t.Run("it should send the new passwords", func(t *testing.T) {
@@ -202,6 +271,131 @@ func TestWatcher(t *testing.T) {
 			}
 		})
 	})
+
+	t.Run("it should not send the credentials of a corrupt service infos", func(t *testing.T) {
+		serviceKey := "/services_infos/test-watcher-corrupt"
+		res, err := KAPI().Set(t.Context(), serviceKey, `{"name":"test-watcher-corrupt","user":"user","password":"password"}`, nil)
+		require.NoError(t, err)
+
+		credentialsChan := make(chan Credentials, 1)
+		go watch(t.Context(), serviceKey, res.Node.ModifiedIndex, &Service{Name: "test-watcher-corrupt"}, Credentials{User: "user", Password: "password"}, credentialsChan, make(chan struct{}, 1))
+
+		_, err = KAPI().Set(t.Context(), serviceKey, `{"name":`, nil)
+		require.NoError(t, err)
+		_, err = KAPI().Set(t.Context(), serviceKey, `{"name":"test-watcher-corrupt","user":"new-user","password":"new-password"}`, nil)
+		require.NoError(t, err)
+
+		select {
+		case credentials := <-credentialsChan:
+			assert.Equal(t, Credentials{User: "new-user", Password: "new-password"}, credentials)
+		case <-time.After(3 * time.Second):
+			t.Fatal("the new credentials have not been sent")
+		}
+	})
+
+	t.Run("it should resync the credentials when the watched index has been cleared", func(t *testing.T) {
+		serviceKey := "/services_infos/test-watcher-resync"
+		res, err := KAPI().Set(t.Context(), serviceKey, `{"name":"test-watcher-resync","user":"user","password":"password"}`, nil)
+		require.NoError(t, err)
+		id := res.Node.ModifiedIndex
+
+		_, err = KAPI().Set(t.Context(), serviceKey, `{"name":"test-watcher-resync","user":"new-user","password":"new-password"}`, nil)
+		require.NoError(t, err)
+		// Clear the etcd event history
+		for i := 0; i < 1001; i++ {
+			_, err = KAPI().Set(t.Context(), "/test-watcher-resync-history", fmt.Sprint(i), nil)
+			require.NoError(t, err)
+		}
+
+		credentialsChan := make(chan Credentials, 1)
+		go watch(t.Context(), serviceKey, id, &Service{Name: "test-watcher-resync"}, Credentials{User: "user", Password: "password"}, credentialsChan, make(chan struct{}, 1))
+
+		select {
+		case credentials := <-credentialsChan:
+			assert.Equal(t, Credentials{User: "new-user", Password: "new-password"}, credentials)
+		case <-time.After(3 * time.Second):
+			t.Fatal("the new credentials have not been sent")
+		}
+	})
+}
+
+func TestServiceInfosRepair(t *testing.T) {
+	t.Run("When the service infos are removed, they should be re-created", func(t *testing.T) {
+		host := genHost("test-service-infos-repair")
+		w := registerForTest(t, t.Context(), "test_service_infos_repair", host, RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		_, err := KAPI().Delete(t.Context(), "/services_infos/test_service_infos_repair", nil)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			s, err := Get(t.Context(), "test_service_infos_repair").Service(t.Context())
+			return err == nil && s.Hostname == "public.dev" && s.User == "user" && s.Password == "password"
+		}, 3*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("When the service infos are corrupt, they should be re-created", func(t *testing.T) {
+		host := genHost("test-service-infos-repair-corrupt")
+		host.Public = false
+		w := registerForTest(t, t.Context(), "test_service_infos_repair_corrupt", host, RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		_, err := KAPI().Set(t.Context(), "/services_infos/test_service_infos_repair_corrupt", `{"name":`, nil)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			res, err := KAPI().Get(t.Context(), "/services_infos/test_service_infos_repair_corrupt", nil)
+			if err != nil {
+				return false
+			}
+			s := &Service{}
+			return json.Unmarshal([]byte(res.Node.Value), s) == nil && s.Name == "test_service_infos_repair_corrupt" && s.User == ""
+		}, 3*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("When the service infos diverged from the host, they should not be overwritten", func(t *testing.T) {
+		w := registerForTest(t, t.Context(), "test_service_infos_repair_diverged", genHost("test-service-infos-repair-diverged"), RegisterOptions{RefreshInterval: 100 * time.Millisecond})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		diverged := `{"name":"test_service_infos_repair_diverged","critical":false,"public":true,"hostname":"old.dev","ports":{"http":"1"},"user":"rotated-user","password":"rotated-password"}`
+		_, err := KAPI().Set(t.Context(), "/services_infos/test_service_infos_repair_diverged", diverged, nil)
+		require.NoError(t, err)
+
+		time.Sleep(500 * time.Millisecond)
+		res, err := KAPI().Get(t.Context(), "/services_infos/test_service_infos_repair_diverged", nil)
+		require.NoError(t, err)
+		assert.JSONEq(t, diverged, res.Node.Value)
+	})
+
+	t.Run("When the service infos lost their credentials, only the credentials should be restored", func(t *testing.T) {
+		w := registerForTest(t, t.Context(), "test_service_infos_repair_credentials", genHost("test-service-infos-repair-credentials"), RegisterOptions{})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+
+		_, err := KAPI().Set(t.Context(), "/services_infos/test_service_infos_repair_credentials", `{"name":"test_service_infos_repair_credentials","public":true,"hostname":"old.dev","ports":{"http":"1"}}`, nil)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			s, err := Get(t.Context(), "test_service_infos_repair_credentials").Service(t.Context())
+			return err == nil && s.User == "user" && s.Password == "password"
+		}, 3*time.Second, 10*time.Millisecond)
+		s, err := Get(t.Context(), "test_service_infos_repair_credentials").Service(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, "old.dev", s.Hostname)
+		assert.Equal(t, Ports{"http": "1"}, s.Ports)
+	})
+
+	t.Run("When the service infos are valid, they should not be overwritten", func(t *testing.T) {
+		serviceKey := "/services_infos/test_service_infos_repair_valid"
+		_, err := KAPI().Set(t.Context(), serviceKey, `{"name":"test_service_infos_repair_valid","user":"user","password":"password"}`, nil)
+		require.NoError(t, err)
+
+		err = repairServiceInfos(t.Context(), serviceKey, &Service{Name: "test_service_infos_repair_valid", User: "other-user", Password: "other-password"}, true, time.Second)
+		require.NoError(t, err)
+
+		s, err := Get(t.Context(), "test_service_infos_repair_valid").Service(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, "user", s.User)
+	})
 }
 
 func TestWithDefaultRegistrationTimeout(t *testing.T) {
@@ -251,7 +445,7 @@ func TestEnsureInitialHostRegistration(t *testing.T) {
 			"test-initial",
 			"/services/test-initial/host-1",
 			"{}",
-			false,
+			heartbeatTTL,
 		)
 
 		require.NoError(t, err)
@@ -271,7 +465,7 @@ func TestEnsureInitialHostRegistration(t *testing.T) {
 			"test-initial-timeout",
 			"/services/test-initial-timeout/host-1",
 			"{}",
-			false,
+			heartbeatTTL,
 		)
 
 		require.ErrorIs(t, err, context.DeadlineExceeded)
@@ -297,7 +491,8 @@ func TestEnsureHostRegistrationWaitsForCallerContext(t *testing.T) {
 			"test-heartbeat",
 			"/services/test-heartbeat/host-1",
 			"{}",
-			false,
+			heartbeatTTL,
+			nil,
 		)
 	}()
 
@@ -323,6 +518,58 @@ func TestEnsureHostRegistrationWaitsForCallerContext(t *testing.T) {
 	}
 }
 
+func TestEnsureHostRegistrationFlagsLostRegistration(t *testing.T) {
+	var requests atomic.Int32
+	recovered := make(chan struct{})
+	useFakeEtcdServer(t, func(w http.ResponseWriter, _ *http.Request) {
+		if requests.Add(1) == 1 {
+			writeEtcdError(t, w)
+			return
+		}
+		<-recovered
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"action":"set","node":{"key":"/services/test-lost/host-1","value":"{}","createdIndex":1,"modifiedIndex":1}}`))
+		assert.NoError(t, err)
+	})
+
+	cred := make(chan Credentials)
+	registration := NewRegistration(t.Context(), "host-1", cred)
+	cred <- Credentials{}
+	require.Eventually(t, registration.Ready, time.Second, 10*time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ensureHostRegistration(t.Context(), "test-lost", "/services/test-lost/host-1", "{}", heartbeatTTL, registration)
+	}()
+
+	require.Eventually(t, func() bool {
+		return !registration.Ready()
+	}, time.Second, 10*time.Millisecond)
+	close(recovered)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the host registration to recover")
+	}
+	assert.True(t, registration.Ready())
+}
+
+// registerForTest registers the host until the end of the test. The cleanup of the test waits
+// for the registration to stop, so that its last requests to etcd do not leak into the next tests.
+func registerForTest(t *testing.T, ctx context.Context, service string, host Host, opts RegisterOptions) *Registration {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := RegisterWithOptions(ctx, service, host, opts)
+	t.Cleanup(func() {
+		cancel()
+		<-w.Done()
+	})
+	return w
+}
+
 func useFakeEtcdServer(t *testing.T, handler http.HandlerFunc) {
 	t.Helper()
 
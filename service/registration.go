@@ -14,33 +14,69 @@ type RegistrationWrapper interface {
 	WaitRegistration(ctx context.Context) error // WaitRegistration waits for the first registration
 	Credentials() (Credentials, error)          // Credentials returns the current credentials or an error if the service is not registered yet
 	UUID() string                               // UUID returns the host UUID
+	Done() <-chan struct{}                      // Done is closed once the registration heartbeat has stopped
+	Err() error                                 // Err returns why the heartbeat stopped, or nil while it is still running
+	Events() <-chan RegistrationEvent           // Events returns the lifecycle events of the registration
+	Peers(ctx context.Context) (*Peers, error)  // Peers returns the other hosts of the service and follows them
+	Shard() string                              // Shard returns the shard of the host
+}
+
+// RegistrationEventType is the type of a RegistrationEvent
+type RegistrationEventType string
+
+const (
+	// RegistrationEventDeleted is sent once the host key, removed by another writer, has been re-registered
+	RegistrationEventDeleted RegistrationEventType = "deleted"
+	// RegistrationEventOverwritten is sent once the host key, overwritten by another writer, has been re-registered
+	RegistrationEventOverwritten RegistrationEventType = "overwritten"
+	// RegistrationEventEvicted is sent when the host has been evicted with Evict. The registration then stops.
+	RegistrationEventEvicted RegistrationEventType = "evicted"
+)
+
+// RegistrationEvent is a lifecycle event of a Registration
+type RegistrationEvent struct {
+	Type RegistrationEventType
 }
 
 // Registration is the RegistrationWrapper implementation used by the Register method
 type Registration struct {
-	credChan        chan Credentials
-	failureChan     chan error
-	readyChan       chan struct{}
-	ready           bool
-	waitErr         error
-	uuid            string
-	mutex           sync.Mutex
-	signalReadyOnce sync.Once
-	curCredentials  *Credentials
+	credChan          chan Credentials
+	readyChan         chan struct{}
+	doneChan          chan struct{}
+	eventsChan        chan RegistrationEvent
+	ready             bool
+	done              bool
+	waitErr           error
+	doneErr           error
+	uuid              string
+	service           string
+	shard             string
+	sharedCredentials bool
+	mutex             sync.Mutex
+	signalReadyOnce   sync.Once
+	signalDoneOnce    sync.Once
+	curCredentials    *Credentials
 }
 
 // NewRegistration initialize the Registration struct
 func NewRegistration(ctx context.Context, uuid string, cred chan Credentials) *Registration {
 	r := &Registration{
-		credChan:        cred,
-		failureChan:     make(chan error, 1),
-		readyChan:       make(chan struct{}),
-		ready:           false,
-		waitErr:         nil,
-		uuid:            uuid,
-		mutex:           sync.Mutex{},
-		signalReadyOnce: sync.Once{},
-		curCredentials:  nil,
+		credChan:          cred,
+		readyChan:         make(chan struct{}),
+		doneChan:          make(chan struct{}),
+		eventsChan:        make(chan RegistrationEvent, 16),
+		ready:             false,
+		done:              false,
+		waitErr:           nil,
+		doneErr:           nil,
+		uuid:              uuid,
+		service:           "",
+		shard:             "",
+		sharedCredentials: false,
+		mutex:             sync.Mutex{},
+		signalReadyOnce:   sync.Once{},
+		signalDoneOnce:    sync.Once{},
+		curCredentials:    nil,
 	}
 	go r.worker(ctx)
 	return r
@@ -69,7 +105,8 @@ func (w *Registration) WaitRegistration(ctx context.Context) error {
 	}
 }
 
-// Ready is a non blocking method that return true if the service is registered to the etcd service false otherwise
+// Ready is a non blocking method that return true if the service is registered to the etcd service false otherwise.
+// It returns false while the host registration is lost, and once the registration heartbeat has stopped.
 func (w *Registration) Ready() bool {
 	w.mutex.Lock()
 	ready := w.ready
@@ -82,6 +119,42 @@ func (w *Registration) UUID() string {
 	return w.uuid
 }
 
+// Done returns a channel which is closed once the registration heartbeat has stopped.
+// After that, the host is not refreshed anymore and Err returns the reason of the stop.
+func (w *Registration) Done() <-chan struct{} {
+	return w.doneChan
+}
+
+// Err returns nil while the registration heartbeat is running. Once Done is closed,
+// it returns the reason why the heartbeat stopped, like the cancellation of the
+// Register context.
+func (w *Registration) Err() error {
+	w.mutex.Lock()
+	err := w.doneErr
+	w.mutex.Unlock()
+	return err
+}
+
+// Shard returns the shard of the host, which may have been assigned automatically. It is empty if the host
+// is not on a shard, or if the shard has not been assigned yet: call it once WaitRegistration returned.
+func (w *Registration) Shard() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.shard
+}
+
+func (w *Registration) setShard(shard string) {
+	w.mutex.Lock()
+	w.shard = shard
+	w.mutex.Unlock()
+}
+
+// Events returns a channel receiving the lifecycle events of the registration, like the re-registration
+// of the host after its key has been removed by another writer. Events are dropped if the channel is full.
+func (w *Registration) Events() <-chan RegistrationEvent {
+	return w.eventsChan
+}
+
 // Credentials return the service credentials or an error if the service is not registered yet
 func (w *Registration) Credentials() (Credentials, error) {
 	w.mutex.Lock()
@@ -100,13 +173,10 @@ func (w *Registration) worker(ctx context.Context) {
 			// Unblock WaitRegistration callers even if the registration never reached etcd.
 			w.signalReady(ctx.Err())
 			return
-		case err := <-w.failureChan:
-			w.signalReady(err)
-			return
 		case newCred := <-w.credChan:
 			w.mutex.Lock()
 			w.curCredentials = &newCred
-			if !w.ready {
+			if !w.ready && !w.done {
 				// The first credentials mark the registration as usable. Later updates only refresh the cache.
 				w.ready = true
 			}
@@ -128,13 +198,40 @@ func (w *Registration) signalReady(err error) {
 	})
 }
 
-func (w *Registration) signalFailure(err error) {
-	if err == nil {
-		return
+// signalDone marks the registration heartbeat as stopped because of err.
+func (w *Registration) signalDone(err error) {
+	w.signalDoneOnce.Do(func() {
+		w.mutex.Lock()
+		w.ready = false
+		w.done = true
+		w.doneErr = err
+		w.mutex.Unlock()
+		// Callers still waiting for the first registration must not wait forever.
+		w.signalReady(err)
+		close(w.doneChan)
+	})
+}
+
+// signalLost flags the registration as not ready while the host key cannot be refreshed.
+func (w *Registration) signalLost() {
+	w.mutex.Lock()
+	w.ready = false
+	w.mutex.Unlock()
+}
+
+// signalRecovered flags the registration as ready again once the host key has been refreshed.
+func (w *Registration) signalRecovered() {
+	w.mutex.Lock()
+	if w.curCredentials != nil && !w.done {
+		w.ready = true
 	}
+	w.mutex.Unlock()
+}
 
+// signalEvent sends a lifecycle event, without blocking if nobody reads the events.
+func (w *Registration) signalEvent(event RegistrationEvent) {
 	select {
-	case w.failureChan <- err:
+	case w.eventsChan <- event:
 	default:
 	}
 }
@@ -66,7 +66,7 @@ func TestWaitRegistration(t *testing.T) {
 
 	t.Run("It must return an error when the context deadline is exceeded", func(t *testing.T) {
 		r := NewRegistration(t.Context(), "1234", make(chan Credentials))
-		r.signalFailure(context.DeadlineExceeded)
+		r.signalDone(context.DeadlineExceeded)
 
 		err := r.WaitRegistration(t.Context())
 		require.ErrorIs(t, err, context.DeadlineExceeded)
@@ -127,3 +127,72 @@ func TestCredentials(t *testing.T) {
 		})
 	})
 }
+
+func TestDone(t *testing.T) {
+	t.Run("While the heartbeat is running", func(t *testing.T) {
+		r := NewRegistration(t.Context(), "1234", make(chan Credentials))
+
+		t.Run("Done should not be closed and Err should be nil", func(t *testing.T) {
+			select {
+			case <-r.Done():
+				t.Fatal("Done should not be closed")
+			default:
+			}
+			require.NoError(t, r.Err())
+		})
+	})
+
+	t.Run("After the heartbeat stopped", func(t *testing.T) {
+		cred := make(chan Credentials)
+		r := NewRegistration(t.Context(), "1234", cred)
+		cred <- Credentials{User: "1", Password: "2"}
+		require.Eventually(t, r.Ready, time.Second, 10*time.Millisecond)
+
+		r.signalDone(context.Canceled)
+
+		t.Run("Done should be closed and Err should return the reason", func(t *testing.T) {
+			select {
+			case <-r.Done():
+			case <-time.After(time.Second):
+				t.Fatal("timed out waiting for Done to be closed")
+			}
+			require.ErrorIs(t, r.Err(), context.Canceled)
+		})
+
+		t.Run("Ready should return false", func(t *testing.T) {
+			assert.False(t, r.Ready())
+		})
+	})
+
+	t.Run("When the first registration fails, it should unblock WaitRegistration", func(t *testing.T) {
+		r := NewRegistration(t.Context(), "1234", make(chan Credentials))
+		r.signalDone(context.DeadlineExceeded)
+
+		err := r.WaitRegistration(t.Context())
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.ErrorIs(t, r.Err(), context.DeadlineExceeded)
+	})
+}
+
+func TestRegistrationLost(t *testing.T) {
+	cred := make(chan Credentials)
+	r := NewRegistration(t.Context(), "1234", cred)
+	cred <- Credentials{User: "1", Password: "2"}
+	require.Eventually(t, r.Ready, time.Second, 10*time.Millisecond)
+
+	t.Run("When the registration is lost, Ready should return false", func(t *testing.T) {
+		r.signalLost()
+		assert.False(t, r.Ready())
+	})
+
+	t.Run("When the registration is recovered, Ready should return true", func(t *testing.T) {
+		r.signalRecovered()
+		assert.True(t, r.Ready())
+	})
+
+	t.Run("When the heartbeat stopped, it should not become ready again", func(t *testing.T) {
+		r.signalDone(context.Canceled)
+		r.signalRecovered()
+		assert.False(t, r.Ready())
+	})
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTPServerOptions configures an HTTPServer.
+type HTTPServerOptions struct {
+	// ListenerOptions configures the registration of the listener of the server.
+	ListenerOptions ListenerOptions
+	// DrainDelay is the time waited by Shutdown between the deregistration of the host and the shutdown of
+	// the server, so that the clients which already picked this host can still reach it.
+	DrainDelay time.Duration
+}
+
+// HTTPServer ties the registration of a host to the lifecycle of an http.Server: the host is registered
+// once the server starts accepting connections, and deregistered before the server shuts down.
+//
+// The http.Server is not exposed, so that it can only be started by the methods of HTTPServer, which all
+// register the host.
+type HTTPServer struct {
+	server       *http.Server
+	service      string
+	opts         HTTPServerOptions
+	mutex        sync.Mutex
+	registration *Registration
+	cancel       context.CancelFunc
+}
+
+// NewHTTPServer returns an HTTPServer registering server as a host of service.
+func NewHTTPServer(service string, server *http.Server, opts HTTPServerOptions) *HTTPServer {
+	return &HTTPServer{
+		server:  server,
+		service: service,
+		opts:    opts,
+	}
+}
+
+// Serve accepts the connections on ln, like http.Server.Serve. The host is registered as soon as the server
+// starts accepting connections, and deregistered once Serve returns. The registration stops when ctx is canceled.
+func (s *HTTPServer) Serve(ctx context.Context, ln net.Listener) error {
+	return s.serve(ctx, ln, s.server.Serve)
+}
+
+// ServeTLS is similar to Serve, but serves HTTPS connections like http.Server.ServeTLS.
+func (s *HTTPServer) ServeTLS(ctx context.Context, ln net.Listener, certFile, keyFile string) error {
+	return s.serve(ctx, ln, func(ln net.Listener) error {
+		return s.server.ServeTLS(ln, certFile, keyFile)
+	})
+}
+
+// ListenAndServe listens on the Addr of the server, ":http" by default, and then calls Serve.
+func (s *HTTPServer) ListenAndServe(ctx context.Context) error {
+	ln, err := s.listen(":http")
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// ListenAndServeTLS listens on the Addr of the server, ":https" by default, and then calls ServeTLS.
+func (s *HTTPServer) ListenAndServeTLS(ctx context.Context, certFile, keyFile string) error {
+	ln, err := s.listen(":https")
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(ctx, ln, certFile, keyFile)
+}
+
+func (s *HTTPServer) listen(defaultAddr string) (net.Listener, error) {
+	addr := s.server.Addr
+	if addr == "" {
+		addr = defaultAddr
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serve registers the host as soon as serveFn starts accepting connections on ln, and deregisters it once
+// serveFn returns.
+func (s *HTTPServer) serve(ctx context.Context, ln net.Listener, serveFn func(net.Listener) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mutex.Lock()
+	s.cancel = cancel
+	s.mutex.Unlock()
+
+	err := serveFn(&registeringListener{
+		Listener: ln,
+		register: func() {
+			registration := RegisterListener(ctx, s.service, ln, s.opts.ListenerOptions)
+			s.mutex.Lock()
+			s.registration = registration
+			s.mutex.Unlock()
+		},
+	})
+
+	// The host must not stay registered once the server is closed
+	cancel()
+	if registration := s.Registration(); registration != nil {
+		<-registration.Done()
+	}
+	return err
+}
+
+// Registration returns the registration of the server, or nil if the server does not accept connections yet.
+func (s *HTTPServer) Registration() *Registration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.registration
+}
+
+// Shutdown deregisters the host, waits for DrainDelay, and then gracefully shuts down the server with
+// http.Server.Shutdown.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	cancel := s.cancel
+	registration := s.registration
+	s.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	if registration != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-registration.Done():
+		}
+	}
+
+	err := sleepOrDone(ctx, s.opts.DrainDelay)
+	if err != nil {
+		return err
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// registeringListener calls register on the first call to Accept, before waiting for a connection. The
+// server calls Accept once it is ready to handle connections, and the clients can only find the host once
+// it is registered.
+type registeringListener struct {
+	net.Listener
+	register func()
+	once     sync.Once
+}
+
+func (l *registeringListener) Accept() (net.Conn, error) {
+	l.once.Do(l.register)
+	return l.Listener.Accept()
+}
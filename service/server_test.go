@@ -0,0 +1,90 @@
+package service
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	etcdv2 "go.etcd.io/etcd/client/v2"
+)
+
+func TestHTTPServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := NewHTTPServer("test_http_server", &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}, HTTPServerOptions{DrainDelay: 100 * time.Millisecond})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(t.Context(), ln)
+	}()
+
+	require.Eventually(t, func() bool {
+		return server.Registration() != nil
+	}, time.Second, 10*time.Millisecond)
+	registration := server.Registration()
+	require.NoError(t, registration.WaitRegistration(t.Context()))
+	hostKey := "/services/test_http_server/" + registration.UUID()
+
+	t.Run("The registered host should reach the server", func(t *testing.T) {
+		url, err := Get(t.Context(), "test_http_server").URL(t.Context(), "http", "/")
+		require.NoError(t, err)
+
+		res, err := http.Get(url)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	})
+
+	t.Run("After a shutdown, the host should be deregistered and the server stopped", func(t *testing.T) {
+		require.NoError(t, server.Shutdown(t.Context()))
+
+		_, err := KAPI().Get(t.Context(), hostKey, nil)
+		assert.True(t, etcdv2.IsKeyNotFound(err))
+
+		select {
+		case err := <-served:
+			require.ErrorIs(t, err, http.ErrServerClosed)
+		case <-time.After(time.Second):
+			t.Fatal("the server is still serving")
+		}
+	})
+}
+
+func TestHTTPServerListenAndServe(t *testing.T) {
+	server := NewHTTPServer("test_http_server_listen", &http.Server{
+		Addr:    "127.0.0.1:0",
+		Handler: http.NotFoundHandler(),
+	}, HTTPServerOptions{})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe(t.Context())
+	}()
+
+	require.Eventually(t, func() bool {
+		return server.Registration() != nil
+	}, time.Second, 10*time.Millisecond)
+	registration := server.Registration()
+	require.NoError(t, registration.WaitRegistration(t.Context()))
+
+	hosts, err := Get(t.Context(), "test_http_server_listen").All(t.Context())
+	require.NoError(t, err)
+	require.Len(t, hosts, 1)
+	assert.NotEqual(t, "0", hosts[0].PrivatePorts["http"])
+
+	require.NoError(t, server.Shutdown(t.Context()))
+	select {
+	case err := <-served:
+		require.ErrorIs(t, err, http.ErrServerClosed)
+	case <-time.After(time.Second):
+		t.Fatal("the server is still serving")
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	stderrors "errors"
 	"fmt"
-	"math/rand"
+	"net/url"
 
 	etcdv2 "go.etcd.io/etcd/client/v2"
 
@@ -12,131 +12,298 @@ import (
 )
 
 var (
-	ErrNoServiceFound     = stderrors.New("service not found")
-	ErrNoHostFound        = stderrors.New("no host found for this service")
-	ErrNoHostFoundOnShard = stderrors.New("no host found for this service on this shard")
-	ErrUnknownScheme      = stderrors.New("unknown scheme")
+	ErrNoServiceFound          = stderrors.New("service not found")
+	ErrNoHostFound             = stderrors.New("no host found for this service")
+	ErrNoHostFoundOnShard      = stderrors.New("no host found for this service on this shard")
+	ErrUnknownScheme           = stderrors.New("unknown scheme")
+	ErrSharedCredentials       = stderrors.New("the credentials are shared by all the hosts of the service")
+	ErrPerHostCredentials      = stderrors.New("the credentials are specific to each host of the service")
+	ErrEmptyCredentials        = stderrors.New("the credentials are empty")
+	ErrInvalidCredentialsScope = stderrors.New("invalid credentials scope")
+	ErrInvalidShard            = stderrors.New("invalid shard")
 )
 
 // Service stores all the information about a service.
 // This is also used to marshal services present in the /services_infos/ directory.
 type Service struct {
-	Name     string `json:"name"`               // Name of the service
-	Critical bool   `json:"critical"`           // Is the service critical to the infrastructure health?
-	Hostname string `json:"hostname,omitempty"` // The service private hostname
-	User     string `json:"user,omitempty"`     // The service username
-	Password string `json:"password,omitempty"` // The service password
-	Ports    Ports  `json:"ports,omitempty"`    // The service private ports
-	Public   bool   `json:"public,omitempty"`   // Is the service public?
+	Name             string           `json:"name"`                        // Name of the service
+	Critical         bool             `json:"critical"`                    // Is the service critical to the infrastructure health?
+	Hostname         string           `json:"hostname,omitempty"`          // The service private hostname
+	User             string           `json:"user,omitempty"`              // The service username
+	Password         string           `json:"password,omitempty"`          // The service password
+	Ports            Ports            `json:"ports,omitempty"`             // The service private ports
+	Public           bool             `json:"public,omitempty"`            // Is the service public?
+	CredentialsScope CredentialsScope `json:"credentials_scope,omitempty"` // Where the service credentials are stored
+}
+
+// Unsharded stands for the hosts which are not on a shard: it is their key in the map returned by
+// Service.Shards, and their shard in QueryReport, QueryOptions.Shard, Shards and ExcludedShards. It is not
+// a valid shard name, Register and RegisterExternal reject a host on this shard.
+const Unsharded = "<unsharded>"
+
+// CredentialsScope tells where the credentials of a service are stored.
+type CredentialsScope string
+
+const (
+	// CredentialsScopeService means that the credentials are stored in /services_infos/<name> and shared by
+	// all the hosts of the service. This is the default scope of public services.
+	CredentialsScopeService CredentialsScope = "service"
+	// CredentialsScopeHost means that each host stores its own credentials in its host key.
+	// This is the default scope of private services.
+	CredentialsScopeHost CredentialsScope = "host"
+)
+
+// withDefault returns the scope of the credentials of a public or of a private service, with its
+// default value if unset.
+func (scope CredentialsScope) withDefault(public bool) CredentialsScope {
+	if scope != "" {
+		return scope
+	}
+	if public {
+		return CredentialsScopeService
+	}
+	return CredentialsScopeHost
+}
+
+// validate returns ErrInvalidCredentialsScope if the scope is unknown, or if the credentials of a
+// public service are not shared: the URL of a public service only uses the credentials of the service.
+func (scope CredentialsScope) validate(public bool) error {
+	switch scope.withDefault(public) {
+	case CredentialsScopeService:
+		return nil
+	case CredentialsScopeHost:
+		if public {
+			return fmt.Errorf("%w: the credentials of a public service are shared by all its hosts", ErrInvalidCredentialsScope)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidCredentialsScope, scope)
 }
 
 // Credentials store service credentials
 type Credentials struct {
-	User     string
-	Password string
+	User     string `json:"user"`
+	Password string `json:"password"`
 }
 
 // QueryOptions allows optional filtering for service queries.
 type QueryOptions struct {
 	Shard string
+	// Shards are shards accepted by the query in addition to Shard.
+	Shards []string
+	// ExcludedShards are shards whose hosts are never returned by the query.
+	ExcludedShards []string
+	// ShardFallback tells which hosts answer the query if the requested shards have no host. Defaults to
+	// ShardFallbackNone.
+	ShardFallback ShardFallbackPolicy
+	// Consistency tells whether the query reads the hosts from the etcd member it is connected to, or with a
+	// quorum read. Defaults to ConsistencyLocal.
+	Consistency Consistency
+	// Balancer chooses the host returned by One and URL. Defaults to RandomBalancer.
+	Balancer Balancer
+	// Locality is the preference of the query for the hosts close to the caller. Defaults to LocalityAny.
+	Locality LocalityPolicy
+	// Zone is the availability zone of the caller. Defaults to the ETCD_DISCOVERY_ZONE environment variable.
+	Zone string
+	// Region is the region of the caller. Defaults to the ETCD_DISCOVERY_REGION environment variable.
+	Region string
+	// MinLocalHosts is the minimum number of hosts in the zone, or in the region, of the caller for the query
+	// to prefer them. Below it, the query falls back to the next locality. Defaults to 1.
+	MinLocalHosts int
 }
 
-// All returns all hosts associated with a service
+// Consistency is the consistency of the etcd reads of a query.
+type Consistency string
+
+const (
+	// ConsistencyLocal reads from the etcd member the client is connected to, which may lag behind the
+	// leader. The reads may be served by the cache of the service. This is the default consistency.
+	ConsistencyLocal Consistency = ""
+	// ConsistencyQuorum reads through the etcd leader, so that the latest registrations are seen. The reads
+	// are never served by the cache of the service.
+	ConsistencyQuorum Consistency = "quorum"
+)
+
+// balancer returns the balancer of the query, with its default value if unset.
+func (o QueryOptions) balancer() Balancer {
+	if o.Balancer != nil {
+		return o.Balancer
+	}
+	return RandomBalancer{}
+}
+
+// All returns all hosts associated with a service. If the query has shards, only the hosts of these shards,
+// or of the shard fallback of the query, are returned. If the query has a locality policy, only the hosts
+// preferred by the policy are returned.
 func (s *Service) All(ctx context.Context, queryOpts QueryOptions) (Hosts, error) {
-	res, err := KAPI().Get(ctx, "/services/"+s.Name, &etcdv2.GetOptions{
-		Recursive: true,
-	})
+	hosts, _, err := s.AllWithReport(ctx, queryOpts)
+	return hosts, err
+}
 
+// AllWithReport is similar to All, but also returns how the query has been answered.
+func (s *Service) AllWithReport(ctx context.Context, queryOpts QueryOptions) (Hosts, QueryReport, error) {
+	hosts, report, err := s.hosts(ctx, queryOpts)
 	if err != nil {
-		if etcdv2.IsKeyNotFound(err) {
-			return nil, ErrNoServiceFound
+		return nil, report, err
+	}
+
+	if len(hosts) == 0 {
+		return nil, report, ErrNoHostFound
+	}
+
+	hosts, report.Fallback, err = queryOpts.selectShards(hosts)
+	if err != nil {
+		return nil, report, err
+	}
+
+	hosts = queryOpts.preferLocal(hosts)
+	report.Shard = hosts[0].shard()
+	for _, host := range hosts[1:] {
+		if host.shard() != report.Shard {
+			report.Shard = ""
+			break
 		}
-		return nil, errors.Wrap(ctx, err, "fetch services")
 	}
+	return hosts, report, nil
+}
 
-	hosts, err := buildHostsFromNodes(ctx, res.Node.Nodes)
+// Shards returns all the hosts of the service grouped by shard. The hosts which are not on a shard are
+// grouped under the Unsharded key.
+func (s *Service) Shards(ctx context.Context) (map[string]Hosts, error) {
+	hosts, _, err := s.hosts(ctx, QueryOptions{})
 	if err != nil {
-		return nil, errors.Wrap(ctx, err, "build hosts from nodes")
+		return nil, err
 	}
 
 	if len(hosts) == 0 {
 		return nil, ErrNoHostFound
 	}
 
-	// If no shard is specified, return all hosts
-	if queryOpts.Shard == "" {
-		return hosts, nil
+	shards := map[string]Hosts{}
+	for _, host := range hosts {
+		shards[host.shard()] = append(shards[host.shard()], host)
 	}
+	return shards, nil
+}
 
-	// If shard is specified, filter hosts by shard
-	filteredHosts := make(Hosts, 0, len(hosts))
-	for _, host := range hosts {
-		if host.Shard == queryOpts.Shard {
-			filteredHosts = append(filteredHosts, host)
+// hosts returns all the hosts of the service, from the cache of the service if any. The report gives the etcd
+// index the hosts have been read at, and the nodes skipped because they are not valid hosts. The quorum
+// reads are never served by the cache.
+func (s *Service) hosts(ctx context.Context, queryOpts QueryOptions) (Hosts, QueryReport, error) {
+	if cache := cacheFor(s.Name); cache != nil && queryOpts.Consistency != ConsistencyQuorum {
+		hosts, index, skipped, found, ok := cache.serviceHosts()
+		if ok {
+			if !found {
+				return nil, QueryReport{Index: index}, ErrNoServiceFound
+			}
+			return hosts, QueryReport{Index: index, Skipped: skipped}, nil
 		}
 	}
 
-	if len(filteredHosts) == 0 {
-		return nil, ErrNoHostFoundOnShard
+	res, err := KAPI().Get(ctx, "/services/"+s.Name, &etcdv2.GetOptions{
+		Recursive: true,
+		Quorum:    queryOpts.Consistency == ConsistencyQuorum,
+	})
+
+	var etcdErr etcdv2.Error
+	if errors.As(err, &etcdErr) && etcdErr.Code == etcdv2.ErrorCodeKeyNotFound {
+		return nil, QueryReport{Index: etcdErr.Index}, ErrNoServiceFound
+	}
+	if err != nil {
+		return nil, QueryReport{}, errors.Wrap(ctx, err, "fetch services")
 	}
 
-	return filteredHosts, nil
+	hosts, skipped := buildHostsFromNodes(ctx, res.Node.Nodes)
+	reportSkippedNodes(ctx, s.Name, skipped, true)
+	return hosts, QueryReport{Index: res.Index, Skipped: skipped}, nil
 }
 
 // First returns the first host of this service
 func (s *Service) First(ctx context.Context, queryOpts QueryOptions) (*Host, error) {
-	hosts, err := s.All(ctx, queryOpts)
+	host, _, err := s.FirstWithReport(ctx, queryOpts)
+	return host, err
+}
+
+// FirstWithReport is similar to First, but also returns how the query has been answered.
+func (s *Service) FirstWithReport(ctx context.Context, queryOpts QueryOptions) (*Host, QueryReport, error) {
+	hosts, report, err := s.AllWithReport(ctx, queryOpts)
 	if err != nil {
-		return nil, errors.Wrap(ctx, err, "fetch all hosts")
+		return nil, report, errors.Wrap(ctx, err, "fetch all hosts")
 	}
 
-	return hosts[0], nil
+	report.Shard = hosts[0].shard()
+	return hosts[0], report, nil
 }
 
-// One returns a random host from all the available hosts of this service.
+// One returns a host chosen by the balancer of the query options among all the available hosts of this
+// service. The host is chosen randomly by default.
 func (s *Service) One(ctx context.Context, queryOpts QueryOptions) (*Host, error) {
-	hosts, err := s.All(ctx, queryOpts)
+	host, _, err := s.OneWithReport(ctx, queryOpts)
+	return host, err
+}
+
+// OneWithReport is similar to One, but also returns how the query has been answered.
+func (s *Service) OneWithReport(ctx context.Context, queryOpts QueryOptions) (*Host, QueryReport, error) {
+	hosts, report, err := s.AllWithReport(ctx, queryOpts)
 	if err != nil {
-		return nil, errors.Wrap(ctx, err, "fetch all hosts")
+		return nil, report, errors.Wrap(ctx, err, "fetch all hosts")
 	}
 
-	return hosts[rand.Int()%len(hosts)], nil
+	host := queryOpts.balancer().Pick(s.Name, queryOpts.shardKey(), hosts)
+	report.Shard = host.shard()
+	return host, report, nil
 }
 
 // URL returns the public url of this service.
 //
-// If this service do not have a public url, this will return a url to a random host.
+// If this service do not have a public url, this will return a url to a host chosen by One.
 func (s *Service) URL(ctx context.Context, scheme, path string, queryOpts QueryOptions) (string, error) {
+	url, _, err := s.URLWithReport(ctx, scheme, path, queryOpts)
+	return url, err
+}
+
+// URLWithReport is similar to URL, but also returns how the query has been answered.
+func (s *Service) URLWithReport(ctx context.Context, scheme, path string, queryOpts QueryOptions) (string, QueryReport, error) {
+	u, report, err := s.urlStruct(ctx, scheme, path, queryOpts)
+	if err != nil {
+		return "", report, err
+	}
+	return u.String(), report, nil
+}
+
+// URLStruct is similar to URL, but returns a *url.URL. The credentials and the path are escaped, and an IPv6
+// hostname is bracketed. path can contain a query and a fragment, it is not unescaped.
+func (s *Service) URLStruct(ctx context.Context, scheme, path string, queryOpts QueryOptions) (*url.URL, error) {
+	u, _, err := s.urlStruct(ctx, scheme, path, queryOpts)
+	return u, err
+}
+
+// urlStruct returns the URL of the service, and how the query has been answered.
+func (s *Service) urlStruct(ctx context.Context, scheme, path string, queryOpts QueryOptions) (*url.URL, QueryReport, error) {
 	// If the service is not public, fallback to a random host.
 	// If a shard is requested, always resolve the URL from a host in that shard.
 	//
 	// The public metadata stored under /services_infos/<name> is shared across
 	// all shards, so it cannot identify the public host for a specific shard.
-	if !s.Public || queryOpts.Shard != "" {
-		host, err := s.One(ctx, queryOpts)
+	if !s.Public || queryOpts.hasShardFilter() {
+		host, report, err := s.OneWithReport(ctx, queryOpts)
 		if err != nil {
-			return "", errors.Wrap(ctx, err, "fetch one host")
+			return nil, report, errors.Wrap(ctx, err, "fetch one host")
 		}
 
-		url, err := host.URL(ctx, scheme, path)
+		u, err := host.URLStruct(ctx, scheme, path)
 		if err != nil {
-			return "", errors.Wrap(ctx, err, "fetch one host url")
+			return nil, report, errors.Wrap(ctx, err, "fetch one host url")
 		}
-		return url, nil
+		return u, report, nil
 	}
 
 	// If the service is public, take the service node.
-	var url string
-	var port string
-	var ok bool
-	if port, ok = s.Ports[scheme]; !ok {
-		return "", ErrUnknownScheme
+	report := QueryReport{Shard: Unsharded, Fallback: ShardFallbackNone}
+	port, ok := s.Ports[scheme]
+	if !ok {
+		return nil, report, ErrUnknownScheme
 	}
 
-	if s.User != "" {
-		url = fmt.Sprintf("%s://%s:%s@%s:%s%s", scheme, s.User, s.Password, s.Hostname, port, path)
-	} else {
-		url = fmt.Sprintf("%s://%s:%s%s", scheme, s.Hostname, port, path)
-	}
-	return url, nil
+	return buildURL(scheme, s.User, s.Password, s.Hostname, port, path), report, nil
 }
@@ -51,6 +51,63 @@ func (mr *MockRegistrationWrapperMockRecorder) Credentials() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Credentials", reflect.TypeOf((*MockRegistrationWrapper)(nil).Credentials))
 }
 
+// Done mocks base method.
+func (m *MockRegistrationWrapper) Done() <-chan struct{} {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Done")
+	ret0, _ := ret[0].(<-chan struct{})
+	return ret0
+}
+
+// Done indicates an expected call of Done.
+func (mr *MockRegistrationWrapperMockRecorder) Done() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Done", reflect.TypeOf((*MockRegistrationWrapper)(nil).Done))
+}
+
+// Err mocks base method.
+func (m *MockRegistrationWrapper) Err() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Err")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Err indicates an expected call of Err.
+func (mr *MockRegistrationWrapperMockRecorder) Err() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Err", reflect.TypeOf((*MockRegistrationWrapper)(nil).Err))
+}
+
+// Events mocks base method.
+func (m *MockRegistrationWrapper) Events() <-chan service.RegistrationEvent {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Events")
+	ret0, _ := ret[0].(<-chan service.RegistrationEvent)
+	return ret0
+}
+
+// Events indicates an expected call of Events.
+func (mr *MockRegistrationWrapperMockRecorder) Events() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Events", reflect.TypeOf((*MockRegistrationWrapper)(nil).Events))
+}
+
+// Peers mocks base method.
+func (m *MockRegistrationWrapper) Peers(ctx context.Context) (*service.Peers, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Peers", ctx)
+	ret0, _ := ret[0].(*service.Peers)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Peers indicates an expected call of Peers.
+func (mr *MockRegistrationWrapperMockRecorder) Peers(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Peers", reflect.TypeOf((*MockRegistrationWrapper)(nil).Peers), ctx)
+}
+
 // Ready mocks base method.
 func (m *MockRegistrationWrapper) Ready() bool {
 	m.ctrl.T.Helper()
@@ -65,6 +122,20 @@ func (mr *MockRegistrationWrapperMockRecorder) Ready() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ready", reflect.TypeOf((*MockRegistrationWrapper)(nil).Ready))
 }
 
+// Shard mocks base method.
+func (m *MockRegistrationWrapper) Shard() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Shard")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// Shard indicates an expected call of Shard.
+func (mr *MockRegistrationWrapperMockRecorder) Shard() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shard", reflect.TypeOf((*MockRegistrationWrapper)(nil).Shard))
+}
+
 // UUID mocks base method.
 func (m *MockRegistrationWrapper) UUID() string {
 	m.ctrl.T.Helper()
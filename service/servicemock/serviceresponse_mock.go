@@ -79,6 +79,20 @@ func (mr *MockServiceResponseMockRecorder) First(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "First", reflect.TypeOf((*MockServiceResponse)(nil).First), ctx)
 }
 
+// ForKey mocks base method.
+func (m *MockServiceResponse) ForKey(ctx context.Context, key string) service.HostResponse {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForKey", ctx, key)
+	ret0, _ := ret[0].(service.HostResponse)
+	return ret0
+}
+
+// ForKey indicates an expected call of ForKey.
+func (mr *MockServiceResponseMockRecorder) ForKey(ctx any, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForKey", reflect.TypeOf((*MockServiceResponse)(nil).ForKey), ctx, key)
+}
+
 // One mocks base method.
 func (m *MockServiceResponse) One(ctx context.Context) service.HostResponse {
 	m.ctrl.T.Helper()
@@ -108,6 +122,21 @@ func (mr *MockServiceResponseMockRecorder) Service(ctx any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Service", reflect.TypeOf((*MockServiceResponse)(nil).Service), ctx)
 }
 
+// Shards mocks base method.
+func (m *MockServiceResponse) Shards(ctx context.Context) (map[string]service.Hosts, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Shards", ctx)
+	ret0, _ := ret[0].(map[string]service.Hosts)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Shards indicates an expected call of Shards.
+func (mr *MockServiceResponseMockRecorder) Shards(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shards", reflect.TypeOf((*MockServiceResponse)(nil).Shards), ctx)
+}
+
 // URL mocks base method.
 func (m *MockServiceResponse) URL(ctx context.Context, scheme, path string) (string, error) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"time"
+
+	etcdv2 "go.etcd.io/etcd/client/v2"
+
+	"github.com/Scalingo/go-utils/errors/v3"
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// defaultShardReservationTTL is how long a shard assignment counts in the population of its shard while the
+// assigned host is not registered, see RegisterOptions.ShardReservationTTL.
+const defaultShardReservationTTL = 1 * time.Minute
+
+// shardRoster is stored in /services_shards/<name>. It keeps the shards assigned by Register, so that
+// concurrent registrations are spread over the shards, and so that a restarted instance gets its shard back.
+type shardRoster struct {
+	// Assignments are indexed by instance ID, or by host UUID if the host has no instance ID
+	Assignments map[string]shardAssignment `json:"assignments"`
+}
+
+type shardAssignment struct {
+	Shard string `json:"shard"`
+	UUID  string `json:"uuid"`
+	// SeenAt is the time of the assignment, updated every time the roster is updated while the host is registered
+	SeenAt time.Time `json:"seen_at"`
+	// ReservationTTL is how long the assignment is kept once SeenAt is over
+	ReservationTTL time.Duration `json:"reservation_ttl"`
+}
+
+// autoShards returns the shards declared in the options, or nil if the shard is not assigned automatically.
+func (o RegisterOptions) autoShards() []string {
+	if len(o.Shards) > 0 {
+		return o.Shards
+	}
+	if o.ShardCount == 0 {
+		return nil
+	}
+	shards := make([]string, o.ShardCount)
+	for i := range shards {
+		shards[i] = fmt.Sprintf("shard-%d", i)
+	}
+	return shards
+}
+
+// ensureShardAssignment keeps retrying the shard assignment until it succeeds or the context is canceled.
+func ensureShardAssignment(ctx context.Context, service, uuid, instanceID string, shards []string, reservationTTL time.Duration) (string, error) {
+	ctx, cancel := withDefaultRegistrationTimeout(ctx)
+	defer cancel()
+	log := logger.Get(ctx)
+
+	shard, err := assignShard(ctx, service, uuid, instanceID, shards, reservationTTL)
+	for err != nil {
+		log.WithError(err).Errorf("Fail to assign a shard (%v)", Client().Endpoints())
+		err = sleepOrDone(ctx, 1*time.Second)
+		if err != nil {
+			return "", err
+		}
+		shard, err = assignShard(ctx, service, uuid, instanceID, shards, reservationTTL)
+	}
+	return shard, nil
+}
+
+// assignShard assigns the least populated of shards to the host. The population of a shard is the number of
+// hosts registered on it, plus the hosts which have just been assigned to it and are not registered yet.
+//
+// If the instance ID already has a shard, this shard is kept. The assignments of the other hosts which are not
+// registered and whose reservation is over are removed. The roster is updated with a compare-and-swap, so that
+// concurrent registrations see each other's assignments.
+func assignShard(ctx context.Context, service, uuid, instanceID string, shards []string, reservationTTL time.Duration) (string, error) {
+	rosterKey := fmt.Sprintf("/services_shards/%s", service)
+	assignmentKey := uuid
+	if instanceID != "" {
+		assignmentKey = instanceID
+	}
+
+	for {
+		roster := shardRoster{Assignments: map[string]shardAssignment{}}
+		setOpts := &etcdv2.SetOptions{PrevExist: etcdv2.PrevNoExist}
+		res, err := KAPI().Get(ctx, rosterKey, nil)
+		if err != nil && !isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+			return "", errors.Wrap(ctx, err, "get shard roster")
+		}
+		if err == nil {
+			setOpts = &etcdv2.SetOptions{PrevIndex: res.Node.ModifiedIndex}
+			err = json.Unmarshal([]byte(res.Node.Value), &roster)
+			if err != nil || roster.Assignments == nil {
+				// A corrupt roster is rebuilt from the registered hosts
+				logger.Get(ctx).WithError(err).Errorf("Invalid shard roster '%s', rebuilding it", rosterKey)
+				roster = shardRoster{Assignments: map[string]shardAssignment{}}
+			}
+		}
+
+		liveShards, err := registeredHostShards(ctx, service)
+		if err != nil {
+			return "", err
+		}
+
+		now := time.Now()
+		population := map[string]int{}
+		for _, shard := range liveShards {
+			population[shard]++
+		}
+		for key, assignment := range roster.Assignments {
+			if _, ok := liveShards[assignment.UUID]; ok {
+				assignment.SeenAt = now
+				roster.Assignments[key] = assignment
+				continue
+			}
+			if now.Sub(assignment.SeenAt) < assignment.ReservationTTL {
+				population[assignment.Shard]++
+			} else if key != assignmentKey {
+				// The host is gone and its instance did not come back in time
+				delete(roster.Assignments, key)
+			}
+		}
+
+		assignment, ok := roster.Assignments[assignmentKey]
+		if !ok || !slices.Contains(shards, assignment.Shard) {
+			assignment.Shard = shards[0]
+			for _, shard := range shards[1:] {
+				if population[shard] < population[assignment.Shard] {
+					assignment.Shard = shard
+				}
+			}
+		}
+		assignment.UUID = uuid
+		assignment.SeenAt = now
+		assignment.ReservationTTL = reservationTTL
+		roster.Assignments[assignmentKey] = assignment
+
+		rosterJSON, _ := json.Marshal(roster)
+		_, err = KAPI().Set(ctx, rosterKey, string(rosterJSON), setOpts)
+		if isEtcdError(err, etcdv2.ErrorCodeNodeExist) || isEtcdError(err, etcdv2.ErrorCodeTestFailed) {
+			// Another host has been assigned a shard in the meantime
+			continue
+		}
+		if err != nil {
+			return "", errors.Wrap(ctx, err, "update shard roster")
+		}
+		return assignment.Shard, nil
+	}
+}
+
+// registeredHostShards returns the shards of the hosts registered for a service, by host UUID. The external
+// hosts are not part of the shards population.
+func registeredHostShards(ctx context.Context, service string) (map[string]string, error) {
+	shards := map[string]string{}
+	res, err := KAPI().Get(ctx, "/services/"+service, &etcdv2.GetOptions{Recursive: true})
+	if isEtcdError(err, etcdv2.ErrorCodeKeyNotFound) {
+		return shards, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(ctx, err, "get service hosts")
+	}
+
+	for _, node := range res.Node.Nodes {
+		host, err := buildHostFromNode(ctx, node)
+		if err != nil || host.External {
+			continue
+		}
+		shards[path.Base(node.Key)] = host.Shard
+	}
+	return shards, nil
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoShard(t *testing.T) {
+	t.Run("With hosts starting concurrently, they should be spread over the shards", func(t *testing.T) {
+		registrations := make([]*Registration, 4)
+		for i := range registrations {
+			host := genHost("test-auto-shard")
+			registrations[i] = registerForTest(t, t.Context(), "test_auto_shard", host, RegisterOptions{ShardCount: 2})
+		}
+
+		population := map[string]int{}
+		for _, w := range registrations {
+			require.NoError(t, w.WaitRegistration(t.Context()))
+			population[w.Shard()]++
+
+			s, err := Get(t.Context(), "test_auto_shard").Service(t.Context())
+			require.NoError(t, err)
+			hosts, err := s.All(t.Context(), QueryOptions{Shard: w.Shard()})
+			require.NoError(t, err)
+			assert.Contains(t, hostUUIDs(hosts), w.UUID())
+		}
+		assert.Equal(t, map[string]int{"shard-0": 2, "shard-1": 2}, population)
+	})
+
+	t.Run("With an instance ID, the instance should keep its shard after a restart", func(t *testing.T) {
+		opts := RegisterOptions{Shards: []string{"a", "b", "c"}, InstanceID: "instance-1"}
+		ctx, cancel := context.WithCancel(t.Context())
+		w := registerForTest(t, ctx, "test_auto_shard_instance", genHost("test-auto-shard-instance"), opts)
+		require.NoError(t, w.WaitRegistration(t.Context()))
+		shard := w.Shard()
+		cancel()
+		<-w.Done()
+
+		// Other instances must not take the shard of the stopped instance
+		other := registerForTest(t, t.Context(), "test_auto_shard_instance", genHost("test-auto-shard-instance"), RegisterOptions{Shards: []string{"a", "b", "c"}})
+		require.NoError(t, other.WaitRegistration(t.Context()))
+
+		w = registerForTest(t, t.Context(), "test_auto_shard_instance", genHost("test-auto-shard-instance"), opts)
+		require.NoError(t, w.WaitRegistration(t.Context()))
+		assert.Equal(t, shard, w.Shard())
+		assert.NotEqual(t, shard, other.Shard())
+	})
+
+	t.Run("With stopped instances, their assignments should be removed once their reservation is over", func(t *testing.T) {
+		rosterKey := "/services_shards/test_auto_shard_prune"
+		roster := shardRoster{Assignments: map[string]shardAssignment{
+			"instance-expired":  {Shard: "a", UUID: "expired-uuid", SeenAt: time.Now().Add(-2 * time.Minute), ReservationTTL: time.Minute},
+			"instance-reserved": {Shard: "a", UUID: "reserved-uuid", SeenAt: time.Now(), ReservationTTL: time.Minute},
+		}}
+		rosterJSON, err := json.Marshal(roster)
+		require.NoError(t, err)
+		_, err = KAPI().Set(t.Context(), rosterKey, string(rosterJSON), nil)
+		require.NoError(t, err)
+
+		w := registerForTest(t, t.Context(), "test_auto_shard_prune", genHost("test-auto-shard-prune"), RegisterOptions{Shards: []string{"a", "b"}})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+		// The shard "a" is still reserved for instance-reserved
+		assert.Equal(t, "b", w.Shard())
+
+		res, err := KAPI().Get(t.Context(), rosterKey, nil)
+		require.NoError(t, err)
+		roster = shardRoster{}
+		require.NoError(t, json.Unmarshal([]byte(res.Node.Value), &roster))
+		assert.NotContains(t, roster.Assignments, "instance-expired")
+		assert.Contains(t, roster.Assignments, "instance-reserved")
+		assert.Contains(t, roster.Assignments, w.UUID())
+	})
+
+	t.Run("With a negative shard reservation TTL, the registration should stop", func(t *testing.T) {
+		w := registerForTest(t, t.Context(), "test_auto_shard_invalid", genHost("test-auto-shard-invalid"), RegisterOptions{ShardCount: 2, ShardReservationTTL: -time.Second})
+		require.ErrorIs(t, w.WaitRegistration(t.Context()), ErrInvalidRegisterOptions)
+	})
+
+	t.Run("With a shard set on the host, it should keep it", func(t *testing.T) {
+		host := genHost("test-auto-shard-explicit")
+		host.Shard = "explicit"
+		w := registerForTest(t, t.Context(), "test_auto_shard_explicit", host, RegisterOptions{ShardCount: 2})
+		require.NoError(t, w.WaitRegistration(t.Context()))
+		assert.Equal(t, "explicit", w.Shard())
+	})
+
+	t.Run("With both Shards and ShardCount, the registration should stop", func(t *testing.T) {
+		w := registerForTest(t, t.Context(), "test_auto_shard_invalid", genHost("test-auto-shard-invalid"), RegisterOptions{Shards: []string{"a"}, ShardCount: 2})
+		require.ErrorIs(t, w.WaitRegistration(t.Context()), ErrInvalidRegisterOptions)
+	})
+
+	t.Run("With Unsharded in Shards, the registration should stop", func(t *testing.T) {
+		w := registerForTest(t, t.Context(), "test_auto_shard_invalid", genHost("test-auto-shard-invalid"), RegisterOptions{Shards: []string{"a", Unsharded}})
+		require.ErrorIs(t, w.WaitRegistration(t.Context()), ErrInvalidRegisterOptions)
+	})
+
+	t.Run("With the host on the Unsharded shard, the registration should stop", func(t *testing.T) {
+		host := genHost("test-auto-shard-invalid")
+		host.Shard = Unsharded
+		w := registerForTest(t, t.Context(), "test_auto_shard_invalid", host, RegisterOptions{})
+		require.ErrorIs(t, w.WaitRegistration(t.Context()), ErrInvalidShard)
+
+		_, err := RegisterExternal(t.Context(), "test_auto_shard_invalid", host)
+		require.ErrorIs(t, err, ErrInvalidShard)
+	})
+}
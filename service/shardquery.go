@@ -0,0 +1,87 @@
+package service
+
+import (
+	"slices"
+	"strings"
+)
+
+// ShardFallbackPolicy tells which hosts answer a query when the requested shards have no host.
+type ShardFallbackPolicy string
+
+const (
+	// ShardFallbackNone fails the query with ErrNoHostFoundOnShard. This is the default policy.
+	ShardFallbackNone ShardFallbackPolicy = ""
+	// ShardFallbackUnsharded falls back to the hosts which are not on a shard.
+	ShardFallbackUnsharded ShardFallbackPolicy = "unsharded"
+	// ShardFallbackAny falls back to the hosts which are not on a shard, then to the hosts of any shard
+	// which is not excluded.
+	ShardFallbackAny ShardFallbackPolicy = "any"
+)
+
+// QueryReport tells how a query has been answered. It is returned by the WithReport variants of the queries,
+// so that the callers can log a degraded routing.
+type QueryReport struct {
+	// Shard is the shard of the host returned by First, One, ForKey and URL, or of all the hosts returned by
+	// All. It is Unsharded if the host is not on a shard, or if URL returned the public URL of the service. It
+	// is empty if the hosts returned by All are on several shards.
+	Shard string
+	// Fallback is the fallback which answered the query, or ShardFallbackNone if the requested shards
+	// answered it.
+	Fallback ShardFallbackPolicy
+	// Index is the etcd index the hosts, or the service infos of the public URL, have been read at.
+	Index uint64
+	// Skipped are the nodes of the service which have been skipped because they are not valid hosts.
+	Skipped []SkippedNode
+}
+
+// requestedShards returns the shards requested by Shard and Shards, sorted.
+func (o QueryOptions) requestedShards() []string {
+	shards := slices.Clone(o.Shards)
+	if o.Shard != "" {
+		shards = append(shards, o.Shard)
+	}
+	slices.Sort(shards)
+	return slices.Compact(shards)
+}
+
+// shardKey identifies the requested shards, for the state of the balancers.
+func (o QueryOptions) shardKey() string {
+	return strings.Join(o.requestedShards(), ",")
+}
+
+// hasShardFilter returns true if the query filters the hosts by shard.
+func (o QueryOptions) hasShardFilter() bool {
+	return len(o.requestedShards()) > 0 || len(o.ExcludedShards) > 0
+}
+
+// selectShards returns the hosts of the requested shards, or of the fallback of the query if the requested
+// shards have no host. The hosts of the excluded shards are never returned. Without requested shards, all
+// the hosts which are not excluded are returned.
+func (o QueryOptions) selectShards(hosts Hosts) (Hosts, ShardFallbackPolicy, error) {
+	hosts = hosts.filter(func(h *Host) bool { return !slices.Contains(o.ExcludedShards, h.shard()) })
+
+	requested := o.requestedShards()
+	if len(requested) == 0 {
+		if len(hosts) == 0 {
+			return nil, ShardFallbackNone, ErrNoHostFoundOnShard
+		}
+		return hosts, ShardFallbackNone, nil
+	}
+
+	selected := hosts.filter(func(h *Host) bool { return slices.Contains(requested, h.shard()) })
+	if len(selected) > 0 {
+		return selected, ShardFallbackNone, nil
+	}
+
+	if o.ShardFallback == ShardFallbackUnsharded || o.ShardFallback == ShardFallbackAny {
+		selected = hosts.filter(func(h *Host) bool { return h.shard() == Unsharded })
+		if len(selected) > 0 {
+			return selected, ShardFallbackUnsharded, nil
+		}
+	}
+
+	if o.ShardFallback == ShardFallbackAny && len(hosts) > 0 {
+		return hosts, ShardFallbackAny, nil
+	}
+	return nil, ShardFallbackNone, ErrNoHostFoundOnShard
+}
@@ -54,7 +54,26 @@ func SubscribeDown(ctx context.Context, service string) (<-chan string, <-chan *
 
 // SubscribeNew returns a channel that will notice you every time a new host is registered.
 // The subscription lifetime is tied to ctx so callers can stop the blocking etcd watch cleanly.
+//
+// A new host which is not valid is skipped, logged and handed to the handler set with SetSkippedNodesHandler.
+// The errors channel only receives the error which stopped the subscription. Use SubscribeNewWithSkipped to
+// also receive the skipped nodes.
 func SubscribeNew(ctx context.Context, service string) (<-chan *Host, <-chan *etcdv2.Error) {
+	return subscribeNew(ctx, service, nil)
+}
+
+// SubscribeNewWithSkipped is similar to SubscribeNew, but also sends the new nodes which are skipped because
+// they are not valid hosts on the skipped channel, which is closed along with the hosts channel. The skipped
+// nodes do not stop the subscription.
+func SubscribeNewWithSkipped(ctx context.Context, service string) (<-chan *Host, <-chan SkippedNode, <-chan *etcdv2.Error) {
+	skipped := make(chan SkippedNode)
+	hosts, errs := subscribeNew(ctx, service, skipped)
+	return hosts, skipped, errs
+}
+
+// subscribeNew implements SubscribeNew. The skipped nodes are sent on skipped unless it is nil, and skipped
+// is closed once the subscription stops.
+func subscribeNew(ctx context.Context, service string, skipped chan SkippedNode) (<-chan *Host, <-chan *etcdv2.Error) {
 	hosts := make(chan *Host)
 	errs := make(chan *etcdv2.Error, 1)
 	watcher := subscribeWatcher(service)
@@ -65,6 +84,7 @@ func SubscribeNew(ctx context.Context, service string) (<-chan *Host, <-chan *et
 			err error
 		)
 
+	watch:
 		for {
 			// Watch with the caller context so this goroutine exits as soon as the subscription is canceled.
 			res, err = watcher.Next(ctx)
@@ -76,9 +96,20 @@ func SubscribeNew(ctx context.Context, service string) (<-chan *Host, <-chan *et
 				// etcd can report the first write for a key as "set" instead of
 				// "create". A missing previous node still means a brand-new host.
 				host, err := buildHostFromNode(ctx, res.Node)
-				if err == nil {
-					hosts <- host
+				if err != nil {
+					node := SkippedNode{Key: res.Node.Key, Index: res.Node.ModifiedIndex, Reason: err.Error()}
+					reportSkippedNodes(ctx, service, []SkippedNode{node}, false)
+					if skipped == nil {
+						continue
+					}
+					select {
+					case <-ctx.Done():
+						break watch
+					case skipped <- node:
+					}
+					continue
 				}
+				hosts <- host
 			}
 		}
 
@@ -88,6 +119,9 @@ func SubscribeNew(ctx context.Context, service string) (<-chan *Host, <-chan *et
 		}
 
 		close(hosts)
+		if skipped != nil {
+			close(skipped)
+		}
 		close(errs)
 	}()
 	return hosts, errs
@@ -106,7 +106,7 @@ func TestSubscribeDown(t *testing.T) {
 	defer cancelSubscription()
 
 	t.Run("When the service 'test' is watched and a host expired", func(t *testing.T) {
-		w := Register(registrationCtx, "test_expiration", genHost("test-expiration"))
+		w := registerForTest(t, registrationCtx, "test_expiration", genHost("test-expiration"), RegisterOptions{})
 		hosts, _ := SubscribeDown(subscriptionCtx, "test_expiration")
 		require.NoError(t, w.WaitRegistration(t.Context()))
 
@@ -157,13 +157,79 @@ func TestSubscribeNew(t *testing.T) {
 		hosts, _ := SubscribeNew(t.Context(), "test_new")
 		time.Sleep(200 * time.Millisecond)
 		newHost := genHost("test-new")
-		Register(ctx, "test_new", newHost)
+		registerForTest(t, ctx, "test_new", newHost, RegisterOptions{})
 		newHost.Name = "test_new"
 		t.Run("A host should be available in the channel", func(t *testing.T) {
 			host, ok := <-hosts
 			assert.True(t, ok)
 			newHost.UUID = host.UUID
+			newHost.RegisteredAt = host.RegisteredAt
 			assert.Equal(t, *host, newHost)
 		})
 	})
 }
+
+func TestSubscribeNewSkipsInvalidHosts(t *testing.T) {
+	var handled []SkippedNode
+	SetSkippedNodesHandler(func(service string, skipped []SkippedNode) {
+		if service == "test_new_invalid" {
+			handled = append(handled, skipped...)
+		}
+	})
+	subscribeWatcher = func(string) etcdv2.Watcher {
+		return &fakeWatcher{
+			results: []resAndErr{
+				{Response: &etcdv2.Response{Action: "create", Node: &etcdv2.Node{Key: "/services/test_new_invalid/corrupt", Value: "{", ModifiedIndex: 12}}},
+				{Response: &etcdv2.Response{Action: "create", Node: sampleNode}},
+			},
+		}
+	}
+	t.Cleanup(func() {
+		SetSkippedNodesHandler(nil)
+		subscribeWatcher = Subscribe
+	})
+
+	hosts, errs := SubscribeNew(t.Context(), "test_new_invalid")
+
+	host, ok := <-hosts
+	require.True(t, ok)
+	assert.Equal(t, sampleResult, *host)
+	require.Len(t, handled, 1)
+	assert.Equal(t, "/services/test_new_invalid/corrupt", handled[0].Key)
+	assert.Equal(t, uint64(12), handled[0].Index)
+
+	// The errors channel only receives the error which stops the subscription
+	_, ok = <-errs
+	assert.False(t, ok)
+}
+
+func TestSubscribeNewWithSkipped(t *testing.T) {
+	subscribeWatcher = func(string) etcdv2.Watcher {
+		return &fakeWatcher{
+			results: []resAndErr{
+				{Response: &etcdv2.Response{Action: "create", Node: &etcdv2.Node{Key: "/services/test_new_skipped/corrupt", Value: "{", ModifiedIndex: 12}}},
+				{Response: &etcdv2.Response{Action: "create", Node: sampleNode}},
+			},
+		}
+	}
+	t.Cleanup(func() {
+		subscribeWatcher = Subscribe
+	})
+
+	hosts, skipped, errs := SubscribeNewWithSkipped(t.Context(), "test_new_skipped")
+
+	node, ok := <-skipped
+	require.True(t, ok)
+	assert.Equal(t, "/services/test_new_skipped/corrupt", node.Key)
+	assert.Equal(t, uint64(12), node.Index)
+
+	host, ok := <-hosts
+	require.True(t, ok)
+	assert.Equal(t, sampleResult, *host)
+
+	// The skipped nodes do not stop the subscription, which is stopped by the end of the watch
+	_, ok = <-errs
+	assert.False(t, ok)
+	_, ok = <-skipped
+	assert.False(t, ok)
+}